@@ -0,0 +1,124 @@
+package invoke
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// BalancerFunc 函数形式的负载均衡器
+type BalancerFunc func(candidates []*Endpoint, key string) *Endpoint
+
+func (fn BalancerFunc) Pick(candidates []*Endpoint, key string) *Endpoint {
+	return fn(candidates, key)
+}
+
+// RoundRobin 轮询
+func RoundRobin() Balancer {
+	var next uint64
+	return BalancerFunc(func(candidates []*Endpoint, key string) *Endpoint {
+		n := atomic.AddUint64(&next, 1) - 1
+		return candidates[n%uint64(len(candidates))]
+	})
+}
+
+// LeastPending 选择进行中请求最少的地址，相同时轮询
+func LeastPending() Balancer {
+	var next uint64
+	return BalancerFunc(func(candidates []*Endpoint, key string) *Endpoint {
+		offset := int(atomic.AddUint64(&next, 1) % uint64(len(candidates)))
+
+		var picked *Endpoint
+		for i := range candidates {
+			ep := candidates[(offset+i)%len(candidates)]
+			if picked == nil || ep.Pending() < picked.Pending() {
+				picked = ep
+			}
+		}
+		return picked
+	})
+}
+
+// Weighted 平滑加权轮询，权重见 Endpoint.Weight，权重<=0的地址视为1
+// 长时间没有参与选择的地址(例如已从地址集中移除)的状态会被清理
+func Weighted() Balancer {
+	type state struct {
+		current int
+		seen    uint64
+	}
+
+	var (
+		mutex   sync.Mutex
+		picks   uint64
+		current = make(map[string]*state)
+	)
+
+	return BalancerFunc(func(candidates []*Endpoint, key string) *Endpoint {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		picks++
+		total := 0
+		var picked *state
+		var pickedEp *Endpoint
+		for _, ep := range candidates {
+			weight := ep.Weight()
+			if weight <= 0 {
+				weight = 1
+			}
+			total += weight
+
+			st := current[ep.Addr]
+			if st == nil {
+				st = &state{}
+				current[ep.Addr] = st
+			}
+			st.current += weight
+			st.seen = picks
+
+			if picked == nil || st.current > picked.current {
+				picked, pickedEp = st, ep
+			}
+		}
+		picked.current -= total
+
+		// 定期清理，不参与选择超过weightedIdlePicks次的地址
+		if picks%weightedIdlePicks == 0 {
+			for addr, st := range current {
+				if picks-st.seen >= weightedIdlePicks {
+					delete(current, addr)
+				}
+			}
+		}
+		return pickedEp
+	})
+}
+
+const weightedIdlePicks = 1024
+
+// ConsistentHash 按请求的均衡键做一致性哈希(rendezvous hashing)，
+// 地址增减时只有落在变化地址上的键会迁移，未指定均衡键时退化为轮询
+func ConsistentHash() Balancer {
+	fallback := RoundRobin()
+	return BalancerFunc(func(candidates []*Endpoint, key string) *Endpoint {
+		if key == "" {
+			return fallback.Pick(candidates, key)
+		}
+
+		var (
+			picked   *Endpoint
+			maxScore uint64
+		)
+		for _, ep := range candidates {
+			h := fnv.New64a()
+			h.Write([]byte(ep.Addr))
+			h.Write([]byte{0})
+			h.Write([]byte(key))
+			score := h.Sum64()
+			if picked == nil || score > maxScore {
+				picked, maxScore = ep, score
+			}
+		}
+		return picked
+	})
+}
@@ -0,0 +1,184 @@
+package invoke
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// streamBody 流式请求体，不会完整读入内存
+type streamBody struct {
+	open       func() (io.ReadCloser, error)
+	size       int64 // 小于0表示长度未知，使用chunked编码
+	replayable bool  // 能否重复打开，不能重复打开的请求体不会重试
+}
+
+// Form 使用 application/x-www-form-urlencoded 请求体
+func (invoker *Invoker) Form(values url.Values) *Invoker {
+	invoker.Header("Content-Type", "application/x-www-form-urlencoded")
+	return invoker.Payload([]byte(values.Encode()))
+}
+
+// Stream 使用流式请求体，size小于0时使用chunked编码
+// r 只能读取一次，因此该请求不会重试
+func (invoker *Invoker) Stream(r io.Reader, size int64) *Invoker {
+	if invoker.headers["Content-Type"] == "" {
+		invoker.Header("Content-Type", "application/octet-stream")
+	}
+
+	invoker.payload = nil
+	invoker.stream = &streamBody{
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		},
+		size: size,
+	}
+	return invoker
+}
+
+// Multipart 使用 multipart/form-data 请求体
+// 只包含内存数据时请求体被完整构建，包含文件或者Reader时使用流式请求体
+func (invoker *Invoker) Multipart(form *MultipartForm) *Invoker {
+	invoker.Header("Content-Type", "multipart/form-data; boundary="+form.boundary)
+
+	if form.inMemory() {
+		var buffer bytes.Buffer
+		err := form.writeTo(&buffer)
+		b := buffer.Bytes()
+
+		invoker.stream = nil
+		invoker.payload = func() ([]byte, error) {
+			if err != nil {
+				return nil, &InvokeError{"BuildMultipart", err, false, false}
+			}
+			return b, nil
+		}
+		return invoker
+	}
+
+	invoker.payload = nil
+	invoker.stream = &streamBody{
+		open: func() (io.ReadCloser, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(form.writeTo(pw))
+			}()
+			return pr, nil
+		},
+		size:       -1,
+		replayable: form.replayable(),
+	}
+	return invoker
+}
+
+type multipartPart struct {
+	field    string
+	filename string
+	value    []byte
+	reader   io.Reader
+	path     string
+}
+
+// MultipartForm multipart/form-data 请求体构建器
+type MultipartForm struct {
+	parts    []multipartPart
+	boundary string
+}
+
+func NewMultipartForm() *MultipartForm {
+	return &MultipartForm{
+		boundary: multipart.NewWriter(ioutil.Discard).Boundary(),
+	}
+}
+
+// Field 普通字段
+func (form *MultipartForm) Field(name, value string) *MultipartForm {
+	form.parts = append(form.parts, multipartPart{field: name, value: []byte(value)})
+	return form
+}
+
+// File 内存中的文件
+func (form *MultipartForm) File(field, filename string, content []byte) *MultipartForm {
+	form.parts = append(form.parts, multipartPart{field: field, filename: filename, value: content})
+	return form
+}
+
+// FileReader 从r读取的文件，只能读取一次
+func (form *MultipartForm) FileReader(field, filename string, r io.Reader) *MultipartForm {
+	form.parts = append(form.parts, multipartPart{field: field, filename: filename, reader: r})
+	return form
+}
+
+// FilePath 本地文件，每次发送时打开
+func (form *MultipartForm) FilePath(field, path string) *MultipartForm {
+	form.parts = append(form.parts, multipartPart{field: field, filename: filepath.Base(path), path: path})
+	return form
+}
+
+func (form *MultipartForm) inMemory() bool {
+	for _, part := range form.parts {
+		if part.reader != nil || part.path != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (form *MultipartForm) replayable() bool {
+	for _, part := range form.parts {
+		if part.reader != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (form *MultipartForm) writeTo(w io.Writer) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(form.boundary); err != nil {
+		return err
+	}
+
+	for _, part := range form.parts {
+		if part.filename == "" {
+			if err := writer.WriteField(part.field, string(part.value)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		partWriter, err := writer.CreateFormFile(part.field, part.filename)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case part.reader != nil:
+			_, err = io.Copy(partWriter, part.reader)
+		case part.path != "":
+			err = copyFile(partWriter, part.path)
+		default:
+			_, err = partWriter.Write(part.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
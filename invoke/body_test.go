@@ -0,0 +1,249 @@
+package invoke
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// bodyServer 记录最后一次请求的请求体，/fail 返回503
+type bodyServer struct {
+	*httptest.Server
+	hits          int64
+	contentLength int64
+	chunked       bool
+	form          url.Values
+	files         map[string]string
+}
+
+func newBodyServer() *bodyServer {
+	server := &bodyServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&server.hits, 1)
+		server.contentLength = r.ContentLength
+		server.chunked = len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked"
+		server.files = map[string]string{}
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for field, headers := range r.MultipartForm.File {
+				f, _ := headers[0].Open()
+				b, _ := ioutil.ReadAll(f)
+				f.Close()
+				server.files[field] = headers[0].Filename + ":" + string(b)
+			}
+		} else if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		server.form = r.PostForm
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	return server
+}
+
+func (server *bodyServer) addr() string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestForm(t *testing.T) {
+	server := newBodyServer()
+	defer server.Close()
+
+	_, err := Addr(server.addr()).Post("/").Form(url.Values{"symbol": {"BTC_USDT"}, "memo": {"a&b c"}}).AutoCloseResponseBody().Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.form.Get("symbol") != "BTC_USDT" || server.form.Get("memo") != "a&b c" {
+		t.Fatalf("unexpected form %v", server.form)
+	}
+	if server.contentLength != int64(len("memo=a%26b+c&symbol=BTC_USDT")) {
+		t.Fatalf("unexpected content length %d", server.contentLength)
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	server := newBodyServer()
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte("a,b\n1,2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 内存中的表单长度已知
+	form := NewMultipartForm().Field("kind", "kyc").File("front", "front.png", []byte("png"))
+	if _, err := Addr(server.addr()).Post("/").Multipart(form).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if server.form.Get("kind") != "kyc" || server.files["front"] != "front.png:png" {
+		t.Fatalf("unexpected in-memory form %v %v", server.form, server.files)
+	}
+	if server.contentLength <= 0 || server.chunked {
+		t.Fatalf("in-memory form should have content length, got %d", server.contentLength)
+	}
+
+	// 包含文件与Reader时流式发送
+	form = NewMultipartForm().Field("kind", "report").FilePath("report", path).FileReader("log", "app.log", strings.NewReader("line"))
+	if _, err := Addr(server.addr()).Post("/").Multipart(form).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if server.form.Get("kind") != "report" || server.files["report"] != "report.csv:a,b\n1,2\n" || server.files["log"] != "app.log:line" {
+		t.Fatalf("unexpected streamed form %v %v", server.form, server.files)
+	}
+	if !server.chunked {
+		t.Fatal("streamed form should use chunked encoding")
+	}
+
+	// 只包含文件路径时可以重试
+	atomic.StoreInt64(&server.hits, 0)
+	form = NewMultipartForm().FilePath("report", path)
+	if _, err := Addr(server.addr()).Post("/fail").Multipart(form).Retry(2).RetryAnyMethod().AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if hits := atomic.LoadInt64(&server.hits); hits != 3 {
+		t.Fatalf("replayable form should be retried, hits=%d", hits)
+	}
+
+	// Reader只能读取一次，不会重试
+	atomic.StoreInt64(&server.hits, 0)
+	form = NewMultipartForm().FileReader("log", "app.log", strings.NewReader("line"))
+	if _, err := Addr(server.addr()).Post("/fail").Multipart(form).Retry(2).RetryAnyMethod().AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if hits := atomic.LoadInt64(&server.hits); hits != 1 {
+		t.Fatalf("form with reader should not be retried, hits=%d", hits)
+	}
+}
+
+func TestStream(t *testing.T) {
+	server := newBodyServer()
+	defer server.Close()
+
+	payload := bytes.Repeat([]byte("x"), 4096)
+	if _, err := Addr(server.addr()).Post("/").Stream(bytes.NewReader(payload), int64(len(payload))).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if server.contentLength != int64(len(payload)) || server.chunked {
+		t.Fatalf("known size should set content length, got %d", server.contentLength)
+	}
+
+	if _, err := Addr(server.addr()).Post("/").Stream(bytes.NewReader(payload), -1).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if server.contentLength != -1 || !server.chunked {
+		t.Fatalf("unknown size should use chunked encoding, got %d", server.contentLength)
+	}
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	atomic.StoreInt64(&server.hits, 0)
+	if _, err := Addr(server.addr()).Post("/fail").Stream(bytes.NewReader(payload), -1).Retry(2).RetryAnyMethod().AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if hits := atomic.LoadInt64(&server.hits); hits != 1 {
+		t.Fatalf("stream should not be retried, hits=%d", hits)
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "InvokeFailed" || entry.Data["payload"] != "<stream>" {
+		t.Fatalf("stream payload should be logged as placeholder, got %+v", entry)
+	}
+}
+
+// closeTracker 统计请求体被关闭的次数
+type closeTracker struct {
+	io.ReadCloser
+	closed *int32
+}
+
+func (tracker *closeTracker) Close() error {
+	atomic.AddInt32(tracker.closed, 1)
+	return tracker.ReadCloser.Close()
+}
+
+func trackStream(invoker *Invoker) *int32 {
+	closed := new(int32)
+	open := invoker.stream.open
+	invoker.stream.open = func() (io.ReadCloser, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &closeTracker{ReadCloser: rc, closed: closed}, nil
+	}
+	return closed
+}
+
+func TestStreamClosedWhenNotSent(t *testing.T) {
+	server := newBodyServer()
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(path, []byte("a,b\n1,2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	form := NewMultipartForm().Field("kind", "report").FilePath("report", path)
+
+	// 限流快速失败
+	invoker := Addr(server.addr()).Post("/").Multipart(form).RateLimit(NewRateLimiter(1, time.Minute).FailFast()).RequestWeight(2)
+	closed := trackStream(invoker)
+	if _, err := invoker.Request(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expect rate limited, got %v", err)
+	}
+	if atomic.LoadInt32(closed) != 1 {
+		t.Fatal("body should be closed when rate limited")
+	}
+
+	// 等待令牌时context结束
+	limiter := NewRateLimiter(1, time.Hour)
+	if _, err := Addr(server.addr()).Get("/").RateLimit(limiter).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	invoker = Addr(server.addr()).Post("/").Multipart(form).RateLimit(limiter).Context(ctx)
+	closed = trackStream(invoker)
+	if _, err := invoker.Request(); err == nil {
+		t.Fatal("expect context error while waiting for tokens")
+	}
+	if atomic.LoadInt32(closed) != 1 {
+		t.Fatal("body should be closed when waiting for tokens is canceled")
+	}
+
+	// 签名失败
+	invoker = Addr(server.addr()).Post("/").Multipart(form).Signer(SignerFunc(func(req *http.Request, body []byte) error {
+		return errors.New("no key")
+	}))
+	closed = trackStream(invoker)
+	if _, err := invoker.Request(); err == nil {
+		t.Fatal("expect sign error")
+	}
+	if atomic.LoadInt32(closed) != 1 {
+		t.Fatal("body should be closed when signing fails")
+	}
+
+	if hits := atomic.LoadInt64(&server.hits); hits != 1 {
+		t.Fatalf("only the limiter warm up request should be sent, hits=%d", hits)
+	}
+}
@@ -0,0 +1,430 @@
+package invoke
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStatus 一次调用的缓存结果，出现在日志的cache字段
+type CacheStatus int32
+
+const (
+	CacheNone CacheStatus = iota
+	// CacheMiss 没有可用的缓存，响应来自上游
+	CacheMiss
+	// CacheHit 缓存新鲜，没有请求上游
+	CacheHit
+	// CacheRevalidated 缓存过期，上游返回304后继续使用
+	CacheRevalidated
+	// CacheStale 在stale-while-revalidate窗口内返回过期缓存，后台重新验证
+	CacheStale
+	// CacheBypass 请求不可缓存
+	CacheBypass
+)
+
+func (status CacheStatus) String() string {
+	switch status {
+	case CacheMiss:
+		return "MISS"
+	case CacheHit:
+		return "HIT"
+	case CacheRevalidated:
+		return "REVALIDATED"
+	case CacheStale:
+		return "STALE"
+	case CacheBypass:
+		return "BYPASS"
+	}
+	return ""
+}
+
+// CachedResponse 缓存的响应
+type CachedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Vary 响应Vary头部列出的请求头部在缓存时的取值
+	Vary   map[string]string `json:"vary,omitempty"`
+	Stored time.Time         `json:"stored"`
+}
+
+// CacheStore 缓存的存储，实现需要并发安全
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, entry *CachedResponse)
+	Delete(key string)
+}
+
+// backgroundRevalidateTimeout 后台重新验证的超时
+const backgroundRevalidateTimeout = time.Minute
+
+// DefaultCacheCredentialHeaders 携带凭证的请求头部
+var DefaultCacheCredentialHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie",
+	"X-Api-Key", "Api-Key", "X-Mbx-Apikey", "Ok-Access-Key",
+}
+
+// Cache 遵循HTTP缓存语义的客户端缓存，只缓存GET请求
+// 支持 max-age, no-store, no-cache, stale-while-revalidate, Expires, ETag/Last-Modified重新验证与Vary
+// 携带凭证的请求只使用与保存 Cache-Control: public 的响应，除非凭证头部通过 KeyHeaders 加入了缓存的键
+type Cache struct {
+	store       CacheStore
+	now         func() time.Time
+	keyHeaders  []string
+	credentials []string
+
+	mutex        sync.Mutex
+	revalidating map[string]bool
+}
+
+// NewCache 使用store创建缓存，同一个 Cache 可以被多个invoker共享
+func NewCache(store CacheStore) *Cache {
+	return &Cache{
+		store:        store,
+		now:          time.Now,
+		credentials:  DefaultCacheCredentialHeaders,
+		revalidating: make(map[string]bool),
+	}
+}
+
+// KeyHeaders 参与缓存键的请求头部，例如按Authorization区分用户缓存私有的响应
+func (cache *Cache) KeyHeaders(headers ...string) *Cache {
+	cache.keyHeaders = append(cache.keyHeaders[:len(cache.keyHeaders):len(cache.keyHeaders)], headers...)
+	return cache
+}
+
+// CredentialHeaders 追加携带凭证的请求头部
+func (cache *Cache) CredentialHeaders(headers ...string) *Cache {
+	cache.credentials = append(cache.credentials[:len(cache.credentials):len(cache.credentials)], headers...)
+	return cache
+}
+
+func (cache *Cache) key(req *http.Request) string {
+	if len(cache.keyHeaders) == 0 {
+		return req.URL.String()
+	}
+
+	var builder strings.Builder
+	builder.WriteString(req.URL.String())
+	for _, header := range cache.keyHeaders {
+		builder.WriteByte('\n')
+		builder.WriteString(http.CanonicalHeaderKey(header))
+		builder.WriteByte(':')
+		builder.WriteString(strings.Join(req.Header.Values(header), ","))
+	}
+	return builder.String()
+}
+
+// credentialed 请求是否携带了没有参与缓存键的凭证
+func (cache *Cache) credentialed(req *http.Request) bool {
+	for _, header := range cache.credentials {
+		if req.Header.Get(header) != "" && !matchName(cache.keyHeaders, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// storable 响应是否可以为该请求保存，携带凭证时只保存public的响应
+func (cache *Cache) storable(req *http.Request, rsp *http.Response) bool {
+	if !storable(rsp) {
+		return false
+	}
+	return !cache.credentialed(req) || parseCacheControl(rsp.Header)["public"] != ""
+}
+
+// Cache 启用响应缓存
+func (invoker *Invoker) Cache(cache *Cache) *Invoker {
+	invoker.cache = cache
+	return invoker
+}
+
+// Do 从缓存返回响应，或通过do请求上游并按响应的缓存头部保存
+func (cache *Cache) Do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	info := invokeInfoFrom(req)
+	if req.Method != http.MethodGet || parseCacheControl(req.Header)["no-store"] != "" {
+		info.setCache(CacheBypass)
+		return do(req)
+	}
+
+	key := cache.key(req)
+	entry, ok := cache.store.Get(key)
+	if ok && !entry.matchVary(req) {
+		ok = false
+	}
+	if ok && cache.credentialed(req) && parseCacheControl(entry.Header)["public"] == "" {
+		ok = false
+	}
+	if !ok {
+		info.setCache(CacheMiss)
+		return cache.fetch(key, req, do)
+	}
+
+	now := cache.now()
+	age := entry.age(now)
+	control := parseCacheControl(entry.Header)
+	fresh := entry.freshness()
+	if control["no-cache"] == "" && parseCacheControl(req.Header)["no-cache"] == "" {
+		if age < fresh {
+			info.setCache(CacheHit)
+			return entry.response(req), nil
+		}
+
+		if swr, err := strconv.Atoi(control["stale-while-revalidate"]); err == nil && age < fresh+time.Duration(swr)*time.Second {
+			info.setCache(CacheStale)
+			cache.revalidateInBackground(key, entry, req, do)
+			return entry.response(req), nil
+		}
+	}
+
+	return cache.revalidate(key, entry, req, do)
+}
+
+// fetch 请求上游并保存可缓存的响应
+func (cache *Cache) fetch(key string, req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	rsp, err := do(req)
+	if err != nil {
+		return rsp, err
+	}
+	return cache.save(key, req, rsp)
+}
+
+// save 可缓存时读取完整的响应体后保存，返回基于缓存的响应
+func (cache *Cache) save(key string, req *http.Request, rsp *http.Response) (*http.Response, error) {
+	rsp.Request = req
+	if !cache.storable(req, rsp) {
+		return rsp, nil
+	}
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CachedResponse{
+		StatusCode: rsp.StatusCode,
+		Header:     rsp.Header,
+		Body:       body,
+		Vary:       varyValues(rsp.Header, req),
+		Stored:     cache.now(),
+	}
+	cache.store.Set(key, entry)
+	return entry.response(req), nil
+}
+
+// revalidate 携带条件头部请求上游，304时刷新并返回缓存
+func (cache *Cache) revalidate(key string, entry *CachedResponse, req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	info := invokeInfoFrom(req)
+
+	etag := entry.Header.Get("ETag")
+	lastModified := entry.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		cache.store.Delete(key)
+		info.setCache(CacheMiss)
+		return cache.fetch(key, req, do)
+	}
+
+	conditional := req.Clone(req.Context())
+	if etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	rsp, err := do(conditional)
+	if err != nil {
+		return rsp, err
+	}
+
+	if rsp.StatusCode != http.StatusNotModified {
+		info.setCache(CacheMiss)
+		if !cache.storable(req, rsp) {
+			cache.store.Delete(key)
+		}
+		return cache.save(key, req, rsp)
+	}
+	rsp.Body.Close()
+
+	refreshed := &CachedResponse{
+		StatusCode: entry.StatusCode,
+		Header:     entry.Header.Clone(),
+		Body:       entry.Body,
+		Vary:       entry.Vary,
+		Stored:     cache.now(),
+	}
+	for name, values := range rsp.Header {
+		refreshed.Header[name] = values
+	}
+	cache.store.Set(key, refreshed)
+
+	info.setCache(CacheRevalidated)
+	return refreshed.response(req), nil
+}
+
+// revalidateInBackground 每个键同时只有一个后台重新验证
+func (cache *Cache) revalidateInBackground(key string, entry *CachedResponse, req *http.Request, do func(*http.Request) (*http.Response, error)) {
+	cache.mutex.Lock()
+	if cache.revalidating[key] {
+		cache.mutex.Unlock()
+		return
+	}
+	cache.revalidating[key] = true
+	cache.mutex.Unlock()
+
+	// 后台请求不再属于本次调用，不携带invokeInfo
+	ctx, cancel := context.WithTimeout(withInvokeInfo(detachedContext{req.Context()}, &invokeInfo{}), backgroundRevalidateTimeout)
+	background := req.Clone(ctx)
+
+	go func() {
+		defer func() {
+			cancel()
+			cache.mutex.Lock()
+			delete(cache.revalidating, key)
+			cache.mutex.Unlock()
+		}()
+
+		rsp, err := cache.revalidate(key, entry, background, do)
+		if err == nil {
+			ioutil.ReadAll(rsp.Body)
+			rsp.Body.Close()
+		}
+	}()
+}
+
+func (info *invokeInfo) setCache(status CacheStatus) {
+	if info != nil {
+		atomic.StoreInt32(&info.cache, int32(status))
+	}
+}
+
+func (info *invokeInfo) cacheStatus() CacheStatus {
+	return CacheStatus(atomic.LoadInt32(&info.cache))
+}
+
+func (entry *CachedResponse) response(req *http.Request) *http.Response {
+	return newBufferedResponse(req, entry.StatusCode, entry.Header.Clone(), nil, entry.Body)
+}
+
+// age 缓存的当前年龄，包括上游返回的Age
+func (entry *CachedResponse) age(now time.Time) time.Duration {
+	age := now.Sub(entry.Stored)
+	if seconds, err := strconv.Atoi(entry.Header.Get("Age")); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age
+}
+
+// freshness 优先使用max-age，其次Expires，否则立即过期
+func (entry *CachedResponse) freshness() time.Duration {
+	control := parseCacheControl(entry.Header)
+	if seconds, err := strconv.Atoi(control["max-age"]); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if expires, err := http.ParseTime(entry.Header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(entry.Header.Get("Date"))
+		if err != nil {
+			date = entry.Stored
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
+func (entry *CachedResponse) matchVary(req *http.Request) bool {
+	for name, value := range entry.Vary {
+		if strings.Join(req.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func varyValues(header http.Header, req *http.Request) map[string]string {
+	var values map[string]string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[name] = strings.Join(req.Header.Values(name), ",")
+		}
+	}
+	return values
+}
+
+// storable 响应是否可以缓存
+func storable(rsp *http.Response) bool {
+	switch rsp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+
+	control := parseCacheControl(rsp.Header)
+	if control["no-store"] != "" {
+		return false
+	}
+	for _, line := range rsp.Header.Values("Vary") {
+		if strings.TrimSpace(line) == "*" {
+			return false
+		}
+	}
+
+	_, hasMaxAge := control["max-age"]
+	return hasMaxAge ||
+		rsp.Header.Get("Expires") != "" ||
+		rsp.Header.Get("ETag") != "" ||
+		rsp.Header.Get("Last-Modified") != ""
+}
+
+// parseCacheControl 解析Cache-Control，没有值的指令取值为指令名本身
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, found := strings.Cut(part, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !found {
+				directives[name] = name
+				continue
+			}
+			directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return directives
+}
+
+func newBufferedResponse(req *http.Request, statusCode int, header, trailer http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Trailer:       trailer,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
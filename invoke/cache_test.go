@@ -0,0 +1,174 @@
+package invoke
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func cachedGet(t *testing.T, invoker *Invoker) (string, CacheStatus) {
+	t.Helper()
+
+	status := CacheNone
+	rsp, err := invoker.Copy().Logger(func(d time.Duration, req *http.Request, rsp *http.Response, err error) {
+		if info := invokeInfoFrom(req); info != nil {
+			status = info.cacheStatus()
+		}
+	}).Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	b, _ := ioutil.ReadAll(rsp.Body)
+	return string(b), status
+}
+
+func TestCache(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/swr":
+			w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get("Accept-Language")))
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	cache := NewCache(NewMemoryCacheStore(16))
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	for _, c := range []struct {
+		path   string
+		expect []CacheStatus
+		hits   int64
+	}{
+		{"/fresh", []CacheStatus{CacheMiss, CacheHit}, 1},
+		{"/etag", []CacheStatus{CacheMiss, CacheRevalidated}, 2},
+		{"/nostore", []CacheStatus{CacheMiss, CacheMiss}, 2},
+	} {
+		atomic.StoreInt64(&hits, 0)
+		for _, expect := range c.expect {
+			body, status := cachedGet(t, Addr(addr).Get(c.path).Cache(cache))
+			if body != "body" || status != expect {
+				t.Fatalf("%s expect %s got %s %q", c.path, expect, status, body)
+			}
+		}
+		if n := atomic.LoadInt64(&hits); n != c.hits {
+			t.Fatalf("%s expect %d upstream calls, got %d", c.path, c.hits, n)
+		}
+	}
+
+	// 过期但在stale-while-revalidate窗口内
+	cachedGet(t, Addr(addr).Get("/swr").Cache(cache))
+	now = now.Add(10 * time.Second)
+	if _, status := cachedGet(t, Addr(addr).Get("/swr").Cache(cache)); status != CacheStale {
+		t.Fatalf("expect stale got %s", status)
+	}
+
+	// Vary的头部不同时不使用缓存
+	if body, status := cachedGet(t, Addr(addr).Get("/vary").Header("Accept-Language", "en").Cache(cache)); body != "en" || status != CacheMiss {
+		t.Fatalf("unexpected %s %q", status, body)
+	}
+	if body, status := cachedGet(t, Addr(addr).Get("/vary").Header("Accept-Language", "zh").Cache(cache)); body != "zh" || status != CacheMiss {
+		t.Fatalf("unexpected %s %q", status, body)
+	}
+	if body, status := cachedGet(t, Addr(addr).Get("/vary").Header("Accept-Language", "zh").Cache(cache)); body != "zh" || status != CacheHit {
+		t.Fatalf("unexpected %s %q", status, body)
+	}
+}
+
+func TestCacheStores(t *testing.T) {
+	memory := NewMemoryCacheStore(2)
+	for _, key := range []string{"a", "b", "c"} {
+		memory.Set(key, &CachedResponse{StatusCode: 200})
+	}
+	if _, ok := memory.Get("a"); ok || memory.Len() != 2 {
+		t.Fatal("expect least recently used entry evicted")
+	}
+
+	disk, err := NewDiskCacheStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("key", &CachedResponse{StatusCode: 200, Header: http.Header{"Etag": {`"v1"`}}, Body: []byte("body")})
+	entry, ok := disk.Get("key")
+	if !ok || string(entry.Body) != "body" || entry.Header.Get("ETag") != `"v1"` {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	disk.Delete("key")
+	if _, ok := disk.Get("key"); ok {
+		t.Fatal("expect deleted")
+	}
+}
+
+func TestCacheCredentials(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Write([]byte("shared"))
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	cache := NewCache(NewMemoryCacheStore(16))
+
+	// 没有Vary时不同用户的私有响应不共享
+	for _, user := range []string{"alice", "bob", "alice"} {
+		body, status := cachedGet(t, Addr(addr).Get("/me").Header("Authorization", user).Cache(cache))
+		if body != user || status != CacheMiss {
+			t.Fatalf("%s: unexpected %s %q", user, status, body)
+		}
+	}
+
+	// public的响应可以共享
+	cachedGet(t, Addr(addr).Get("/public").Header("Authorization", "alice").Cache(cache))
+	if body, status := cachedGet(t, Addr(addr).Get("/public").Header("Authorization", "bob").Cache(cache)); body != "shared" || status != CacheHit {
+		t.Fatalf("unexpected %s %q", status, body)
+	}
+
+	// 凭证参与缓存键时按用户缓存
+	atomic.StoreInt64(&hits, 0)
+	keyed := NewCache(NewMemoryCacheStore(16)).KeyHeaders("Authorization")
+	for _, c := range []struct {
+		user   string
+		status CacheStatus
+	}{{"alice", CacheMiss}, {"bob", CacheMiss}, {"alice", CacheHit}, {"bob", CacheHit}} {
+		body, status := cachedGet(t, Addr(addr).Get("/me").Header("Authorization", c.user).Cache(keyed))
+		if body != c.user || status != c.status {
+			t.Fatalf("%s: expect %s got %s %q", c.user, c.status, status, body)
+		}
+	}
+	if n := atomic.LoadInt64(&hits); n != 2 {
+		t.Fatalf("expect 2 upstream calls, got %d", n)
+	}
+}
@@ -0,0 +1,146 @@
+package invoke
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryCacheStore 内存中的LRU缓存
+type MemoryCacheStore struct {
+	maxEntries int
+
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CachedResponse
+}
+
+// NewMemoryCacheStore maxEntries为最多缓存的响应数，超出时淘汰最久未使用的
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (store *MemoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	element, ok := store.entries[key]
+	if !ok {
+		return nil, false
+	}
+	store.order.MoveToFront(element)
+	return element.Value.(*memoryCacheItem).entry, true
+}
+
+func (store *MemoryCacheStore) Set(key string, entry *CachedResponse) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if element, ok := store.entries[key]; ok {
+		element.Value.(*memoryCacheItem).entry = entry
+		store.order.MoveToFront(element)
+		return
+	}
+
+	store.entries[key] = store.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for store.maxEntries > 0 && store.order.Len() > store.maxEntries {
+		oldest := store.order.Back()
+		store.order.Remove(oldest)
+		delete(store.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (store *MemoryCacheStore) Delete(key string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if element, ok := store.entries[key]; ok {
+		store.order.Remove(element)
+		delete(store.entries, key)
+	}
+}
+
+// Len 当前缓存的响应数
+func (store *MemoryCacheStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.order.Len()
+}
+
+// DiskCacheStore 磁盘缓存，每个响应保存为dir下的一个json文件，可以在进程重启后继续使用
+type DiskCacheStore struct {
+	dir string
+}
+
+// NewDiskCacheStore 目录不存在时创建
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCacheStore{dir: dir}, nil
+}
+
+func (store *DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(store.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (store *DiskCacheStore) Get(key string) (*CachedResponse, bool) {
+	b, err := ioutil.ReadFile(store.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry CachedResponse
+	if err := json.Unmarshal(b, &entry); err != nil {
+		logrus.WithError(err).WithField("key", key).Warnln("DiskCacheCorrupted")
+		store.Delete(key)
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (store *DiskCacheStore) Set(key string, entry *CachedResponse) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	// 先写临时文件再重命名，读取方不会看到写了一半的文件
+	file, err := ioutil.TempFile(store.dir, ".tmp-*")
+	if err != nil {
+		logrus.WithError(err).Warnln("DiskCacheWriteFailed")
+		return
+	}
+	_, err = file.Write(b)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), store.path(key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		logrus.WithError(err).Warnln("DiskCacheWriteFailed")
+	}
+}
+
+func (store *DiskCacheStore) Delete(key string) {
+	os.Remove(store.path(key))
+}
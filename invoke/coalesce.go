@@ -0,0 +1,156 @@
+package invoke
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coalescer 合并相同的并发GET/HEAD请求，相同的请求共享一次HTTP调用
+// 请求的键为 方法,URL 以及指定的头部，每个调用方得到独立可读的响应体，各自的取消互不影响，
+// 所有调用方都放弃时共享的调用才会被取消
+type Coalescer struct {
+	headers []string
+
+	mutex sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	waiters int
+	cancel  context.CancelFunc
+
+	statusCode int
+	header     http.Header
+	trailer    http.Header
+	body       []byte
+	err        error
+}
+
+// NewCoalescer headers为参与合并键的头部，例如Authorization
+func NewCoalescer(headers ...string) *Coalescer {
+	return &Coalescer{
+		headers: headers,
+		calls:   make(map[string]*coalescedCall),
+	}
+}
+
+// Coalesce 启用请求合并，同一个 Coalescer 的invoker之间合并
+func (invoker *Invoker) Coalesce(coalescer *Coalescer) *Invoker {
+	invoker.coalescer = coalescer
+	return invoker
+}
+
+func (coalescer *Coalescer) key(req *http.Request) string {
+	var builder strings.Builder
+	builder.WriteString(req.Method)
+	builder.WriteByte(' ')
+	builder.WriteString(req.URL.String())
+	for _, header := range coalescer.headers {
+		builder.WriteByte('\n')
+		builder.WriteString(header)
+		builder.WriteByte(':')
+		builder.WriteString(strings.Join(req.Header.Values(header), ","))
+	}
+	return builder.String()
+}
+
+// Do 执行或加入一个相同的进行中的请求
+func (coalescer *Coalescer) Do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return do(req)
+	}
+
+	key := coalescer.key(req)
+
+	coalescer.mutex.Lock()
+	call, exist := coalescer.calls[key]
+	if !exist {
+		ctx, cancel := context.WithCancel(detachedContext{req.Context()})
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		coalescer.calls[key] = call
+		go coalescer.run(key, call, req.WithContext(ctx), do)
+	}
+	call.waiters++
+	coalescer.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		coalescer.leave(key, call)
+	case <-req.Context().Done():
+		coalescer.leave(key, call)
+		return nil, req.Context().Err()
+	}
+
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return newBufferedResponse(req, call.statusCode, call.header.Clone(), call.trailer.Clone(), call.body), nil
+}
+
+func (coalescer *Coalescer) run(key string, call *coalescedCall, req *http.Request, do func(*http.Request) (*http.Response, error)) {
+	defer func() {
+		coalescer.mutex.Lock()
+		coalescer.remove(key, call)
+		coalescer.mutex.Unlock()
+
+		close(call.done)
+	}()
+
+	rsp, err := do(req)
+	if err != nil {
+		call.err = err
+		return
+	}
+	defer rsp.Body.Close()
+
+	call.body, call.err = ioutil.ReadAll(rsp.Body)
+	call.statusCode = rsp.StatusCode
+	call.header = rsp.Header
+	call.trailer = rsp.Trailer
+}
+
+// leave 最后一个离开的调用方取消共享的调用，并将其移除，之后的调用方发起新的调用
+func (coalescer *Coalescer) leave(key string, call *coalescedCall) {
+	coalescer.mutex.Lock()
+	defer coalescer.mutex.Unlock()
+
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		coalescer.remove(key, call)
+	}
+}
+
+// remove 移除key对应的调用，key可能已经对应新的调用
+func (coalescer *Coalescer) remove(key string, call *coalescedCall) {
+	if coalescer.calls[key] == call {
+		delete(coalescer.calls, key)
+	}
+}
+
+// detachedContext 保留父context的值，但不继承其取消与截止时间
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
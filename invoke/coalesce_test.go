@@ -0,0 +1,112 @@
+package invoke
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("shared"))
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	coalescer := NewCoalescer("Authorization")
+
+	// 一个调用方提前取消，不影响其他调用方
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := Addr(addr).Get("/").Coalesce(coalescer).Context(ctx).Request()
+		canceled <- err
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := Addr(addr).Get("/").Coalesce(coalescer).Request()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer rsp.Body.Close()
+
+			b, _ := ioutil.ReadAll(rsp.Body)
+			if string(b) != "shared" {
+				t.Errorf("unexpected body %q", b)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := <-canceled; err == nil {
+		t.Fatal("expect canceled caller to fail")
+	}
+	if n := atomic.LoadInt64(&hits); n != 1 {
+		t.Fatalf("expect 1 upstream call, got %d", n)
+	}
+
+	// 不同的头部不合并
+	Addr(addr).Get("/").Header("Authorization", "a").Coalesce(coalescer).AutoCloseResponseBody().Request()
+	if n := atomic.LoadInt64(&hits); n != 2 {
+		t.Fatalf("expect 2 upstream calls, got %d", n)
+	}
+}
+
+func TestCoalesceAfterAbandon(t *testing.T) {
+	coalescer := NewCoalescer()
+	started := make(chan struct{}, 2)
+	var calls int64
+	do := func(req *http.Request) (*http.Response, error) {
+		started <- struct{}{}
+		if atomic.AddInt64(&calls, 1) == 1 {
+			// 第一个调用被取消后仍需要一段时间才结束
+			<-req.Context().Done()
+			time.Sleep(100 * time.Millisecond)
+			return nil, req.Context().Err()
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("fresh"))}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+	abandoned := make(chan error, 1)
+	go func() {
+		_, err := coalescer.Do(req, do)
+		abandoned <- err
+	}()
+	<-started
+	cancel()
+	if err := <-abandoned; err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	// 被放弃的调用结束之前到达的调用方发起新的调用
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	rsp, err := coalescer.Do(req, do)
+	if err != nil {
+		t.Fatalf("should not share the abandoned call, got %v", err)
+	}
+	defer rsp.Body.Close()
+
+	if b, _ := ioutil.ReadAll(rsp.Body); string(b) != "fresh" {
+		t.Fatalf("unexpected body %q", b)
+	}
+}
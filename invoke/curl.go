@@ -0,0 +1,137 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// errCurlCaptured 生成curl命令时中止真实的发送
+var errCurlCaptured = errors.New("invoke: request captured for curl")
+
+// CurlOnFailure 失败时在日志中记录等价的curl命令
+func (policy *LogPolicy) CurlOnFailure() *LogPolicy {
+	policy.curlOnFailure = true
+	return policy
+}
+
+// Curl 按当前配置构建请求但不发送，返回等价的curl命令，按 LogPolicy 脱敏
+// 请求经过拦截器，BeforeRequest与签名，不经过缓存,限流等，也不执行 AfterRequest
+func (invoker *Invoker) Curl() (string, error) {
+	call := *invoker
+	call.cache = nil
+	call.coalescer = nil
+	call.rateLimiter = nil
+	call.hedge = nil
+	call.retry = 0
+	call.observers = nil
+	call.recorder = nil
+	call.parseStatus = nil
+	call.afterRequests = nil
+	call.logger = nil
+
+	ctx := call.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	call.ctx = ctx
+
+	var captured *http.Request
+	call.doRequest = func(c *http.Client, req *http.Request) (*http.Response, error) {
+		captured = req
+		// 取消后的尝试不计入地址的失败
+		cancel()
+		return nil, errCurlCaptured
+	}
+
+	_, err := call.request()
+	if captured == nil {
+		return "", err
+	}
+	if captured.Body != nil {
+		captured.Body.Close()
+	}
+
+	policy := invoker.logPolicy
+	if policy == nil {
+		policy = defaultLogPolicy
+	}
+	return policy.curl(captured), nil
+}
+
+// curl 渲染请求，请求体使用压缩前的内容
+func (policy *LogPolicy) curl(req *http.Request) string {
+	var args []string
+	add := func(values ...string) {
+		args = append(args, values...)
+	}
+	add("curl")
+
+	switch req.Method {
+	case "", http.MethodGet:
+	case http.MethodHead:
+		add("--head")
+	default:
+		add("-X", req.Method)
+	}
+
+	u := *req.URL
+	host := req.Host
+	if target, ok := lookupTarget(u.Host); ok && strings.HasPrefix(target.addr, UnixPrefix) {
+		add("--unix-socket", shellQuote(target.address))
+		u.Host = host
+	}
+	if host == u.Host {
+		host = ""
+	}
+
+	add(shellQuote(policy.redactURL(&u)))
+
+	info := invokeInfoFrom(req)
+	header := policy.redactHeader(req.Header)
+	if info != nil && info.encoding != "" {
+		header.Del("Content-Encoding")
+	}
+	if host != "" {
+		header.Set("Host", host)
+	}
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			add("-H", shellQuote(name+": "+value))
+		}
+	}
+
+	switch {
+	case info == nil:
+	case info.streamed:
+		add("--data-binary", "@-")
+	case len(info.payload) > 0:
+		// 无法脱敏时不输出内容
+		redacted, ok := policy.redactBody(req.Header.Get("Content-Type"), info.payload)
+		if !ok {
+			redacted = []byte(LogRedacted)
+		}
+		add("--data-raw", shellQuote(string(redacted)))
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote 单引号包裹，适用于sh/bash
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@=", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
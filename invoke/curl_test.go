@@ -0,0 +1,77 @@
+package invoke
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestCurl(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	cmd, err := Addr(addr).
+		Post("/v1/orders/{id}").
+		PathParam("id", "a b").
+		Query("symbol", "BTC").
+		Query("signature", "secret").
+		Header("X-Mbx-Apikey", "my-key").
+		Json(map[string]string{"memo": "it's", "password": "p"}).
+		Curl()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "curl -X POST 'http://" + addr + "/v1/orders/a%20b?signature=REDACTED&symbol=BTC'" +
+		" -H 'X-Mbx-Apikey: REDACTED'" +
+		` --data-raw '{"memo":"it'\''s","password":"REDACTED"}'`
+	if cmd != expect {
+		t.Fatalf("unexpected curl\n%s\n%s", cmd, expect)
+	}
+	if atomic.LoadInt64(&hits) != 0 {
+		t.Fatal("request should not be sent")
+	}
+
+	cmd, err = Addr("unix:///run/agent.sock").Get("/ping").Curl()
+	if err != nil || cmd != "curl --unix-socket /run/agent.sock http://localhost/ping" {
+		t.Fatalf("unexpected curl %q %v", cmd, err)
+	}
+
+	if _, err := Addr(addr).Get("/{id}").PathParam("orderId", 1).Curl(); err == nil {
+		t.Fatal("expect unresolved path param error")
+	}
+}
+
+func TestCurlOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	rsp, err := Addr(strings.TrimPrefix(server.URL, "http://")).
+		Method("PUT", "/v1/config").
+		Header("Authorization", "Bearer token").
+		Payload([]byte("k=v")).
+		LogPolicy(NewLogPolicy().CurlOnFailure()).
+		Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	cmd, _ := hook.LastEntry().Data["curl"].(string)
+	if !strings.HasPrefix(cmd, "curl -X PUT ") || !strings.Contains(cmd, "-H 'Authorization: REDACTED'") || !strings.HasSuffix(cmd, "--data-raw k=v") {
+		t.Fatalf("unexpected curl %q", cmd)
+	}
+}
@@ -0,0 +1,173 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UnixPrefix Unix域套接字地址的前缀，例如 unix:///var/run/agent.sock
+const UnixPrefix = "unix://"
+
+// ErrNoDialer 地址的前缀没有注册dialer，例如注册后又被移除
+var ErrNoDialer = errors.New("invoke: no dialer registered")
+
+// maxDialTargets 记录的注册地址的上限，超出时移除最久未使用的地址
+const maxDialTargets = 4096
+
+// DialFunc 建立到address的连接，address为地址去掉前缀后的部分
+type DialFunc func(ctx context.Context, address string) (net.Conn, error)
+
+var (
+	dialersMutex sync.RWMutex
+	dialers      = map[string]DialFunc{
+		"unix": func(ctx context.Context, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", address)
+		},
+	}
+
+	// dialTargets URL中的host到实际地址
+	dialTargetsMutex sync.RWMutex
+	dialTargets      = make(map[string]*dialTarget)
+)
+
+// RegisterDialer 注册地址前缀，之后 Addr("<scheme>://<address>") 的请求通过dial建立连接，
+// 连接仍由 http.Transport 的连接池复用，不需要为每个地址创建 http.Client，dial为nil时移除注册
+//
+//	invoke.RegisterDialer("vsock", func(ctx context.Context, address string) (net.Conn, error) {
+//		return vsock.Dial(address)
+//	})
+func RegisterDialer(scheme string, dial DialFunc) {
+	dialersMutex.Lock()
+	defer dialersMutex.Unlock()
+	if dial == nil {
+		delete(dialers, scheme)
+		return
+	}
+	dialers[scheme] = dial
+}
+
+func lookupDialer(scheme string) DialFunc {
+	dialersMutex.RLock()
+	defer dialersMutex.RUnlock()
+	return dialers[scheme]
+}
+
+// dialTarget 通过注册的dialer连接的地址
+type dialTarget struct {
+	addr     string
+	scheme   string
+	address  string
+	lastUsed int64 // unix纳秒
+}
+
+// targetHost 返回地址在URL中使用的host
+// 注册的地址在URL中使用由地址生成的host，用于连接池区分不同的地址
+func targetHost(addr string) string {
+	index := strings.Index(addr, "://")
+	if index < 0 {
+		return addr
+	}
+
+	scheme, address := addr[:index], addr[index+3:]
+	if lookupDialer(scheme) == nil {
+		return addr
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(addr))
+	host := fmt.Sprintf("%s-%x.invoke.local", scheme, hash.Sum64())
+	if _, ok := lookupTarget(host); !ok {
+		storeTarget(host, &dialTarget{addr: addr, scheme: scheme, address: address, lastUsed: time.Now().UnixNano()})
+	}
+	return host
+}
+
+// storeTarget 记录地址，超出上限时移除最久未使用的地址
+func storeTarget(host string, target *dialTarget) {
+	dialTargetsMutex.Lock()
+	defer dialTargetsMutex.Unlock()
+
+	if _, ok := dialTargets[host]; ok {
+		return
+	}
+	if len(dialTargets) >= maxDialTargets {
+		var (
+			oldest     string
+			oldestUsed int64
+		)
+		for h, t := range dialTargets {
+			if used := atomic.LoadInt64(&t.lastUsed); oldest == "" || used < oldestUsed {
+				oldest, oldestUsed = h, used
+			}
+		}
+		delete(dialTargets, oldest)
+	}
+	dialTargets[host] = target
+}
+
+// hostHeader URL中的host对应的默认Host请求头，注册的地址为localhost
+func hostHeader(host string) string {
+	if _, ok := lookupTarget(host); ok {
+		return "localhost"
+	}
+	return host
+}
+
+// lookupTarget URL中的host对应的注册地址
+func lookupTarget(host string) (*dialTarget, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	dialTargetsMutex.RLock()
+	target, ok := dialTargets[host]
+	dialTargetsMutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	atomic.StoreInt64(&target.lastUsed, time.Now().UnixNano())
+	return target, true
+}
+
+// displayHost 日志与metrics中使用的地址，注册的地址还原为原始的地址
+func displayHost(host string) string {
+	if target, ok := lookupTarget(host); ok {
+		return target.addr
+	}
+	return host
+}
+
+// DialContext 包装 http.Transport 的DialContext，使其能连接 RegisterDialer 注册的地址，
+// 自定义 http.Client 时使用，注意这些地址不应经过代理
+//
+//	transport.DialContext = invoke.DialContext((&net.Dialer{}).DialContext)
+func DialContext(next func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if target, ok := lookupTarget(address); ok {
+			// 使用当前注册的dialer，允许重新注册
+			dial := lookupDialer(target.scheme)
+			if dial == nil {
+				return nil, fmt.Errorf("%w: %s", ErrNoDialer, target.addr)
+			}
+			return dial(ctx, target.address)
+		}
+		return next(ctx, network, address)
+	}
+}
+
+// proxyFromEnvironment 注册的地址不使用代理
+func proxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	if _, ok := lookupTarget(req.URL.Host); ok {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
@@ -0,0 +1,129 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func newUnixServer(t *testing.T, name string) (*httptest.Server, string) {
+	path := filepath.Join(t.TempDir(), name+".sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + r.Host + " " + r.URL.RequestURI()))
+	}))
+	server.Listener = listener
+	server.Start()
+	return server, UnixPrefix + path
+}
+
+func TestUnixSocket(t *testing.T) {
+	first, firstAddr := newUnixServer(t, "first")
+	defer first.Close()
+	second, secondAddr := newUnixServer(t, "second")
+	defer second.Close()
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	call := func(invoker *Invoker) string {
+		rsp, err := invoker.OnlyStatus200().Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		b, _ := ioutil.ReadAll(rsp.Body)
+		return string(b)
+	}
+
+	policy := NewLogPolicy().Levels(logrus.InfoLevel, logrus.WarnLevel)
+	if got := call(Addr(firstAddr).Get("/v1/status").Query("a", "1").LogPolicy(policy)); got != "first localhost /v1/status?a=1" {
+		t.Fatalf("unexpected response %q", got)
+	}
+	if url := hook.LastEntry().Data["url"].(string); url != firstAddr+"/v1/status?a=1" {
+		t.Fatalf("unexpected log url %q", url)
+	}
+
+	if got := call(Addr(secondAddr).Get("/").Header("Host", "agent.internal")); got != "second agent.internal /" {
+		t.Fatalf("unexpected response %q", got)
+	}
+
+	// 同一个连接池中不同的套接字不能混用连接
+	if got := call(Addr(firstAddr).Get("/")); !strings.HasPrefix(got, "first ") {
+		t.Fatalf("unexpected response %q", got)
+	}
+}
+
+func TestRegisterDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	var dialed string
+	RegisterDialer("testdial", func(ctx context.Context, address string) (net.Conn, error) {
+		dialed = address
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", strings.TrimPrefix(server.URL, "http://"))
+	})
+
+	upstream := NewUpstream("testdial://backend")
+	rsp, err := Addr("orders").Upstream(upstream).Get("/").OnlyStatus200().Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	b, _ := ioutil.ReadAll(rsp.Body)
+	if dialed != "backend" || string(b) != "localhost" {
+		t.Fatalf("unexpected dial %q host %q", dialed, b)
+	}
+}
+
+func TestUnregisterDialer(t *testing.T) {
+	RegisterDialer("testgone", func(ctx context.Context, address string) (net.Conn, error) {
+		return nil, errors.New("should not dial")
+	})
+	host := targetHost("testgone://backend")
+	RegisterDialer("testgone", nil)
+
+	dial := DialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("should not fall through")
+	})
+	if _, err := dial(context.Background(), "tcp", net.JoinHostPort(host, "80")); !errors.Is(err, ErrNoDialer) {
+		t.Fatalf("expect ErrNoDialer, got %v", err)
+	}
+}
+
+func TestDialTargetsBound(t *testing.T) {
+	prefix := fmt.Sprintf("unix:///tmp/bound-%d", time.Now().UnixNano())
+	first := targetHost(prefix + "-first.sock")
+	for i := 0; i < maxDialTargets; i++ {
+		targetHost(fmt.Sprintf("%s-%d.sock", prefix, i))
+	}
+
+	dialTargetsMutex.RLock()
+	n := len(dialTargets)
+	dialTargetsMutex.RUnlock()
+	if n > maxDialTargets {
+		t.Fatalf("dial targets not bounded, %d entries", n)
+	}
+	if _, ok := lookupTarget(first); ok {
+		t.Fatal("least recently used target should be evicted")
+	}
+}
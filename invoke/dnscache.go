@@ -0,0 +1,279 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// DNSCache 带后台刷新的DNS缓存
+// 定期重新解析使用中的域名，长时间未使用的域名被移除，解析失败时继续使用上一次成功的结果
+type DNSCache struct {
+	lookup          func(ctx context.Context, host string) ([]string, error)
+	timeout         time.Duration
+	refreshInterval time.Duration
+	idleTimeout     time.Duration
+	roundRobin      bool
+	metrics         *Metrics
+
+	mutex   sync.RWMutex
+	entries map[string]*dnsEntry
+	group   singleflight.Group
+
+	start sync.Once
+	stop  chan struct{}
+	once  sync.Once
+}
+
+type dnsEntry struct {
+	ips      []string
+	lastUsed int64 // unix纳秒
+	next     uint32
+}
+
+// NewDNSCache 默认每分钟刷新，10分钟未使用的域名被移除，随机选择IP
+func NewDNSCache() *DNSCache {
+	return &DNSCache{
+		lookup:          net.DefaultResolver.LookupHost,
+		timeout:         5 * time.Second,
+		refreshInterval: time.Minute,
+		idleTimeout:     10 * time.Minute,
+		entries:         make(map[string]*dnsEntry),
+		stop:            make(chan struct{}),
+	}
+}
+
+// Lookup 自定义解析函数
+func (cache *DNSCache) Lookup(fn func(ctx context.Context, host string) ([]string, error)) *DNSCache {
+	cache.lookup = fn
+	return cache
+}
+
+// Timeout 单次解析的超时
+func (cache *DNSCache) Timeout(d time.Duration) *DNSCache {
+	cache.timeout = d
+	return cache
+}
+
+// RefreshInterval 后台刷新的间隔，在第一次解析时开始
+func (cache *DNSCache) RefreshInterval(d time.Duration) *DNSCache {
+	cache.refreshInterval = d
+	return cache
+}
+
+// IdleTimeout 超过该时间未使用的域名在刷新时被移除
+func (cache *DNSCache) IdleTimeout(d time.Duration) *DNSCache {
+	cache.idleTimeout = d
+	return cache
+}
+
+// RoundRobin 轮流使用各个IP，默认随机
+func (cache *DNSCache) RoundRobin() *DNSCache {
+	cache.roundRobin = true
+	return cache
+}
+
+// Metrics 解析失败计入metrics的dns_failures_total
+func (cache *DNSCache) Metrics(metrics *Metrics) *DNSCache {
+	cache.metrics = metrics
+	return cache
+}
+
+// Close 停止后台刷新
+func (cache *DNSCache) Close() {
+	cache.once.Do(func() { close(cache.stop) })
+}
+
+// LookupHost 返回host的IP，顺序按随机或轮询打乱，用于依次尝试
+func (cache *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	cache.start.Do(func() { go cache.refreshLoop() })
+
+	cache.mutex.RLock()
+	entry, ok := cache.entries[host]
+	cache.mutex.RUnlock()
+	if ok {
+		atomic.StoreInt64(&entry.lastUsed, time.Now().UnixNano())
+		return cache.order(entry), nil
+	}
+
+	// 首次解析时触发httptrace的DNS事件，缓存命中时没有DNS解析
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	entry, err := cache.resolveShared(ctx, host)
+	if trace != nil && trace.DNSDone != nil {
+		done := httptrace.DNSDoneInfo{Err: err}
+		if entry != nil {
+			for _, ip := range entry.ips {
+				done.Addrs = append(done.Addrs, net.IPAddr{IP: net.ParseIP(ip)})
+			}
+		}
+		trace.DNSDone(done)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cache.order(entry), nil
+}
+
+// errLookupAbandoned 发起解析的调用方已取消，其他等待的调用方重新解析
+var errLookupAbandoned = errors.New("invoke: dns lookup abandoned")
+
+// resolveShared 并发的首次解析只进行一次，解析使用发起者的ctx，每个调用方可以各自取消等待
+func (cache *DNSCache) resolveShared(ctx context.Context, host string) (*dnsEntry, error) {
+	for {
+		results := cache.group.DoChan(host, func() (interface{}, error) {
+			ips, err := cache.resolve(ctx, host)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, errLookupAbandoned
+				}
+				cache.failed(host, err, false)
+				return nil, err
+			}
+
+			entry := &dnsEntry{ips: ips, lastUsed: time.Now().UnixNano()}
+			cache.mutex.Lock()
+			cache.entries[host] = entry
+			cache.mutex.Unlock()
+			return entry, nil
+		})
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result := <-results:
+			if result.Err == errLookupAbandoned {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			if result.Err != nil {
+				return nil, result.Err
+			}
+			return result.Val.(*dnsEntry), nil
+		}
+	}
+}
+
+func (cache *DNSCache) resolve(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cache.timeout)
+	defer cancel()
+
+	ips, err := cache.lookup(ctx, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, err
+}
+
+func (cache *DNSCache) order(entry *dnsEntry) []string {
+	cache.mutex.RLock()
+	ips := entry.ips
+	cache.mutex.RUnlock()
+
+	ordered := make([]string, len(ips))
+	if cache.roundRobin {
+		offset := int(atomic.AddUint32(&entry.next, 1)-1) % len(ips)
+		n := copy(ordered, ips[offset:])
+		copy(ordered[n:], ips[:offset])
+		return ordered
+	}
+
+	copy(ordered, ips)
+	rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	return ordered
+}
+
+// failed 记录解析失败，stale表示仍在使用上一次成功的结果
+func (cache *DNSCache) failed(host string, err error, stale bool) {
+	logrus.WithError(err).WithFields(logrus.Fields{
+		"host":  host,
+		"stale": stale,
+	}).Warnln("DNSResolveFailed")
+
+	if cache.metrics != nil {
+		cache.metrics.dnsFailed(host, stale)
+	}
+}
+
+func (cache *DNSCache) refreshLoop() {
+	ticker := time.NewTicker(cache.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cache.stop:
+			return
+		case <-ticker.C:
+			cache.Refresh()
+		}
+	}
+}
+
+// Refresh 移除长时间未使用的域名并重新解析其余的域名
+func (cache *DNSCache) Refresh() {
+	idleBefore := time.Now().Add(-cache.idleTimeout).UnixNano()
+
+	cache.mutex.Lock()
+	hosts := make(map[string]*dnsEntry, len(cache.entries))
+	for host, entry := range cache.entries {
+		if atomic.LoadInt64(&entry.lastUsed) < idleBefore {
+			delete(cache.entries, host)
+			continue
+		}
+		hosts[host] = entry
+	}
+	cache.mutex.Unlock()
+
+	// 后台刷新不属于任何请求
+	for host, entry := range hosts {
+		ips, err := cache.resolve(context.Background(), host)
+		if err != nil {
+			cache.failed(host, err, true)
+			continue
+		}
+
+		cache.mutex.Lock()
+		entry.ips = ips
+		cache.mutex.Unlock()
+	}
+}
+
+// DialContext 使用缓存的IP依次尝试连接
+func (cache *DNSCache) DialContext(dialer *net.Dialer) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		ips, err := cache.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+
+		// 这里做容错
+		return dialer.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,129 @@
+package invoke
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	var (
+		lookups int
+		fail    bool
+		ips     = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	)
+	metrics := NewMetrics("test")
+	cache := NewDNSCache().RoundRobin().Metrics(metrics).Lookup(func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if fail {
+			return nil, errors.New("lookup failed")
+		}
+		return ips, nil
+	})
+	defer cache.Close()
+
+	ctx := context.Background()
+	first, err := cache.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := cache.LookupHost(ctx, "example.com")
+	if lookups != 1 || first[0] == second[0] || len(second) != 3 {
+		t.Fatalf("expect cached round robin, lookups=%d %v %v", lookups, first, second)
+	}
+
+	// 刷新失败时使用上一次成功的结果
+	fail = true
+	cache.Refresh()
+	if got, err := cache.LookupHost(ctx, "example.com"); err != nil || len(got) != 3 {
+		t.Fatalf("expect last known good ips, got %v %v", got, err)
+	}
+
+	var buffer bytes.Buffer
+	metrics.WriteTo(&buffer)
+	if !strings.Contains(buffer.String(), `test_dns_failures_total{host="example.com",result="stale"} 1`) {
+		t.Fatalf("missing dns failure metric:\n%s", buffer.String())
+	}
+
+	// 刷新后使用新的结果
+	fail = false
+	ips = []string{"10.0.0.9"}
+	cache.Refresh()
+	if got, _ := cache.LookupHost(ctx, "example.com"); len(got) != 1 || got[0] != "10.0.0.9" {
+		t.Fatalf("expect refreshed ips, got %v", got)
+	}
+
+	// 未使用的域名被移除
+	cache.IdleTimeout(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	cache.Refresh()
+	lookups = 0
+	cache.LookupHost(ctx, "example.com")
+	if lookups != 1 {
+		t.Fatalf("expect idle entry removed, lookups=%d", lookups)
+	}
+}
+
+func TestDNSCacheCancel(t *testing.T) {
+	var lookups int64
+	cache := NewDNSCache().Lookup(func(ctx context.Context, host string) ([]string, error) {
+		if atomic.AddInt64(&lookups, 1) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []string{"10.0.0.1"}, nil
+	})
+	defer cache.Close()
+
+	// 首次解析可以被调用方取消
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cache.LookupHost(ctx, "example.com"); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("expect lookup canceled with the caller, got %v after %v", err, time.Since(start))
+	}
+
+	// 取消的解析不会影响之后的调用方
+	if ips, err := cache.LookupHost(context.Background(), "example.com"); err != nil || len(ips) != 1 {
+		t.Fatalf("unexpected %v %v", ips, err)
+	}
+}
+
+type dnsObserver struct {
+	NopObserver
+	dns int64
+}
+
+func (observer *dnsObserver) OnDNS(info *ObserveInfo, d time.Duration, err error) {
+	atomic.AddInt64(&observer.dns, 1)
+}
+
+func TestDNSCacheTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	const host = "dns-trace.invoke.test"
+	cache := NewDNSCache().Lookup(func(ctx context.Context, name string) ([]string, error) {
+		return []string{"127.0.0.1"}, nil
+	})
+	defer cache.Close()
+
+	transport := &http.Transport{DialContext: cache.DialContext(&net.Dialer{Timeout: time.Second})}
+	defer transport.CloseIdleConnections()
+
+	observer := &dnsObserver{}
+	if _, err := Addr(net.JoinHostPort(host, port)).Get("/").Client(&http.Client{Transport: transport}).Observe(observer).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&observer.dns); n != 1 {
+		t.Fatalf("expect OnDNS through the cache dialer, got %d", n)
+	}
+}
@@ -0,0 +1,227 @@
+package invoke
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hello-pionex/mystic-go/tinyutil"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// Codec 请求体压缩与响应体解压，名称对应 Content-Encoding
+type Codec interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	codecMutex sync.RWMutex
+	codecs     = map[string]Codec{
+		"gzip":    gzipCodec{},
+		"deflate": &deflateCodec{},
+		"zstd":    &zstdCodec{},
+	}
+)
+
+// RegisterCodec 注册或替换一个编码
+func RegisterCodec(name string, codec Codec) {
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+
+	codecs[strings.ToLower(name)] = codec
+}
+
+func lookupCodec(name string) (Codec, bool) {
+	codecMutex.RLock()
+	defer codecMutex.RUnlock()
+
+	codec, exist := codecs[strings.ToLower(strings.TrimSpace(name))]
+	return codec, exist
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := tinyutil.Gzip.GetWriter(&buffer)
+	if _, err := writer.Write(src); err != nil {
+		tinyutil.Gzip.PutWriter(writer)
+		return nil, err
+	}
+	tinyutil.Gzip.PutWriter(writer) // PutWriter会Close，写入gzip尾部
+	return buffer.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(r io.Reader) (io.ReadCloser, error) {
+	reader := tinyutil.Gzip.GetReader(r)
+	if reader == nil {
+		return nil, fmt.Errorf("invalid gzip stream")
+	}
+	return &pooledReadCloser{Reader: reader, release: func() { tinyutil.Gzip.PutReader(reader) }}, nil
+}
+
+// deflateCodec HTTP的deflate即zlib格式(RFC 1950)，而非裸deflate流
+type deflateCodec struct {
+	writers sync.Pool
+}
+
+func (codec *deflateCodec) Compress(src []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	var writer *zlib.Writer
+	if w := codec.writers.Get(); w != nil {
+		writer = w.(*zlib.Writer)
+		writer.Reset(&buffer)
+	} else {
+		writer = zlib.NewWriter(&buffer)
+	}
+	defer codec.writers.Put(writer)
+
+	if _, err := writer.Write(src); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (codec *deflateCodec) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+}
+
+func (codec *zstdCodec) Compress(src []byte) ([]byte, error) {
+	codec.once.Do(func() {
+		codec.encoder, _ = zstd.NewWriter(nil)
+	})
+	return codec.encoder.EncodeAll(src, nil), nil
+}
+
+func (codec *zstdCodec) Decompress(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// pooledReadCloser 关闭时将reader归还到池中，重复关闭只归还一次
+type pooledReadCloser struct {
+	io.Reader
+	once    sync.Once
+	release func()
+}
+
+func (r *pooledReadCloser) Close() error {
+	r.once.Do(r.release)
+	return nil
+}
+
+// compressPayload 按声明的编码压缩请求体，请求体小于阈值时不压缩，返回是否压缩
+func (invoker *Invoker) compressPayload(body []byte) ([]byte, bool, error) {
+	if invoker.encoding == "" || len(body) == 0 || len(body) < invoker.compressMinSize {
+		return body, false, nil
+	}
+
+	codec, exist := lookupCodec(invoker.encoding)
+	if !exist {
+		return nil, false, &InvokeError{"CompressPayload", fmt.Errorf("unsupported encoding %s", invoker.encoding), false, false}
+	}
+
+	encoded, err := codec.Compress(body)
+	if err != nil {
+		return nil, false, &InvokeError{"CompressPayload", err, false, false}
+	}
+	return encoded, true, nil
+}
+
+// decodedBody 解压响应体并统计压缩前后的大小
+type decodedBody struct {
+	io.ReadCloser
+	raw      io.ReadCloser
+	counter  *countingReader
+	size     int64
+	encoding string
+	url      string
+}
+
+func (body *decodedBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.size += int64(n)
+	return n, err
+}
+
+func (body *decodedBody) Close() error {
+	body.ReadCloser.Close()
+	logrus.WithFields(logrus.Fields{
+		"url":         body.url,
+		"encoding":    body.encoding,
+		"encodedSize": body.counter.n,
+		"decodedSize": body.size,
+	}).Debugln("InvokeResponseDecoded")
+	return body.raw.Close()
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// decompressResponse 按响应的 Content-Encoding 替换为解压后的响应体
+func decompressResponse(rsp *http.Response) error {
+	if rsp == nil || rsp.Body == nil || rsp.Uncompressed {
+		return nil
+	}
+
+	// 没有响应体的响应，例如HEAD，204与304，可能仍带有 Content-Encoding
+	if rsp.ContentLength == 0 ||
+		rsp.StatusCode == http.StatusNoContent ||
+		rsp.StatusCode == http.StatusNotModified ||
+		(rsp.Request != nil && rsp.Request.Method == http.MethodHead) {
+		return nil
+	}
+
+	encoding := rsp.Header.Get("Content-Encoding")
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+
+	codec, exist := lookupCodec(encoding)
+	if !exist {
+		return nil
+	}
+
+	counter := &countingReader{Reader: rsp.Body}
+	reader, err := codec.Decompress(counter)
+	if err != nil {
+		return &InvokeError{"DecompressResponse", err, false, false}
+	}
+
+	urlString := ""
+	if rsp.Request != nil {
+		urlString = rsp.Request.URL.String()
+	}
+
+	rsp.Body = &decodedBody{ReadCloser: reader, raw: rsp.Body, counter: counter, encoding: encoding, url: urlString}
+	rsp.Header.Del("Content-Encoding")
+	rsp.Header.Del("Content-Length")
+	rsp.ContentLength = -1
+	rsp.Uncompressed = true
+	return nil
+}
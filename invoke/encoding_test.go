@@ -0,0 +1,188 @@
+package invoke
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"symbol":"BTC_USDT"}`), 100)
+
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		codec, _ := lookupCodec(encoding)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Encoding") != encoding {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			reader, err := codec.Decompress(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := ioutil.ReadAll(reader)
+			reader.Close()
+
+			encoded, _ := codec.Compress(body)
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(encoded)
+		}))
+
+		rsp, err := Addr(strings.TrimPrefix(server.URL, "http://")).
+			Post("/echo").
+			Encoding(encoding).
+			Payload(payload).
+			Request()
+		if err != nil {
+			t.Fatal(encoding, err)
+		}
+
+		body, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		server.Close()
+
+		if rsp.StatusCode != 200 || !bytes.Equal(body, payload) {
+			t.Fatalf("%s status=%d body mismatch", encoding, rsp.StatusCode)
+		}
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") != "" || string(body) != "small" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	rsp, err := Addr(strings.TrimPrefix(server.URL, "http://")).
+		Post("/").
+		EncodingGzip().
+		CompressMinSize(1024).
+		Payload([]byte("small")).
+		AutoCloseResponseBody().
+		Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != 200 {
+		t.Fatalf("status=%d", rsp.StatusCode)
+	}
+}
+
+func TestDecompressEmptyResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/head":
+			w.Header().Set("Content-Length", "128")
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		case "/empty":
+			w.Header().Set("Content-Length", "0")
+		}
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	cases := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"HEAD", "/head", http.StatusOK},
+		{"GET", "/no-content", http.StatusNoContent},
+		{"GET", "/not-modified", http.StatusNotModified},
+		{"GET", "/empty", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		rsp, err := Addr(addr).Method(c.method, c.path).Request()
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.method, c.path, err)
+		}
+		body, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil || len(body) != 0 || rsp.StatusCode != c.status {
+			t.Fatalf("%s %s: status=%d body=%q err=%v", c.method, c.path, rsp.StatusCode, body, err)
+		}
+	}
+}
+
+func TestDecompressDoubleClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec, _ := lookupCodec("gzip")
+		encoded, _ := codec.Compress(bytes.Repeat([]byte(r.URL.Path), 1000))
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(encoded)
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	rsp, err := Addr(addr).Get("/first").Header("Accept-Encoding", "gzip").Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	rsp.Body.Close()
+
+	// 两个响应同时处于打开状态，若reader被重复归还则会共用同一个gzip reader
+	var bodies []io.ReadCloser
+	for _, path := range []string{"/a", "/b"} {
+		rsp, err := Addr(addr).Get(path).Header("Accept-Encoding", "gzip").Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		bodies = append(bodies, rsp.Body)
+	}
+	for i, path := range []string{"/a", "/b"} {
+		body, err := ioutil.ReadAll(bodies[i])
+		if err != nil || !bytes.Equal(body, bytes.Repeat([]byte(path), 1000)) {
+			t.Fatalf("%s body corrupted, err=%v", path, err)
+		}
+	}
+}
+
+func TestDeflateIsZlib(t *testing.T) {
+	payload := []byte(`{"symbol":"BTC_USDT"}`)
+	codec, _ := lookupCodec("deflate")
+
+	encoded, err := codec.Compress(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(reader); !bytes.Equal(body, payload) {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	writer.Write(payload)
+	writer.Close()
+
+	decoded, err := codec.Decompress(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(decoded); !bytes.Equal(body, payload) {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
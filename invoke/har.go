@@ -0,0 +1,479 @@
+package invoke
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultHARBodySize HAR中记录的响应体的默认最大长度
+const DefaultHARBodySize = 1 << 20
+
+// HAR HTTP Archive 1.2，http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry 一次尝试，重试与对冲会产生多条记录
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // 毫秒
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// HARTimings 各阶段的毫秒数，不适用的阶段为-1，connect包含ssl
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// HARRecorder 记录调用的每次尝试，保存为HAR文件后可以导入浏览器开发者工具
+// 请求与响应按 LogPolicy 脱敏，响应体在读取完或关闭后才会记录
+//
+//	recorder := invoke.NewHARRecorder()
+//	invoker.Record(recorder)
+//	...
+//	recorder.Save("session.har")
+type HARRecorder struct {
+	policy      *LogPolicy
+	maxBodySize int
+
+	mutex   sync.Mutex
+	entries []HAREntry
+}
+
+// NewHARRecorder 使用默认的脱敏列表，响应体最多记录 DefaultHARBodySize
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{
+		policy:      defaultLogPolicy,
+		maxBodySize: DefaultHARBodySize,
+	}
+}
+
+// Redact 脱敏使用的策略，不使用其中的截断长度
+func (recorder *HARRecorder) Redact(policy *LogPolicy) *HARRecorder {
+	recorder.policy = policy
+	return recorder
+}
+
+// MaxBodySize 记录的响应体的最大长度
+func (recorder *HARRecorder) MaxBodySize(n int) *HARRecorder {
+	recorder.maxBodySize = n
+	return recorder
+}
+
+// Record 使用recorder记录调用
+func (invoker *Invoker) Record(recorder *HARRecorder) *Invoker {
+	invoker.recorder = recorder
+	return invoker
+}
+
+// Entries 已记录的尝试，按开始时间排序
+func (recorder *HARRecorder) Entries() []HAREntry {
+	recorder.mutex.Lock()
+	entries := make([]HAREntry, len(recorder.entries))
+	copy(entries, recorder.entries)
+	recorder.mutex.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	return entries
+}
+
+// Reset 清空已记录的尝试，用于开始新的会话
+func (recorder *HARRecorder) Reset() {
+	recorder.mutex.Lock()
+	recorder.entries = nil
+	recorder.mutex.Unlock()
+}
+
+// WriteTo 以HAR格式写出已记录的尝试
+func (recorder *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "mystic-go/invoke", Version: "1.0"},
+		Entries: recorder.Entries(),
+	}}
+
+	b, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Save 写入HAR文件，先写临时文件再替换
+func (recorder *HARRecorder) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := recorder.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// harTimer 通过httptrace记录一次尝试各阶段的时间点
+type harTimer struct {
+	mutex        sync.Mutex
+	start        time.Time
+	getConn      time.Time
+	gotConn      time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wrote        time.Time
+	firstByte    time.Time
+	remoteAddr   string
+}
+
+func (timer *harTimer) mark(t *time.Time) {
+	timer.mutex.Lock()
+	if t.IsZero() {
+		*t = time.Now()
+	}
+	timer.mutex.Unlock()
+}
+
+func (timer *harTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) { timer.mark(&timer.getConn) },
+		GotConn: func(conn httptrace.GotConnInfo) {
+			timer.mark(&timer.gotConn)
+			if host, _, err := net.SplitHostPort(conn.Conn.RemoteAddr().String()); err == nil {
+				timer.mutex.Lock()
+				timer.remoteAddr = host
+				timer.mutex.Unlock()
+			}
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { timer.mark(&timer.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { timer.mark(&timer.dnsDone) },
+		ConnectStart:         func(string, string) { timer.mark(&timer.connectStart) },
+		ConnectDone:          func(string, string, error) { timer.mark(&timer.connectDone) },
+		TLSHandshakeStart:    func() { timer.mark(&timer.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { timer.mark(&timer.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { timer.mark(&timer.wrote) },
+		GotFirstResponseByte: func() { timer.mark(&timer.firstByte) },
+	}
+}
+
+func harSpan(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return -1
+	}
+	return float64(to.Sub(from)) / float64(time.Millisecond)
+}
+
+// timings 计算各阶段耗时，end为响应体读取完成的时间
+func (timer *harTimer) timings(end time.Time) (HARTimings, float64) {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+
+	connectEnd := timer.connectDone
+	if !timer.tlsDone.IsZero() {
+		connectEnd = timer.tlsDone
+	}
+
+	timings := HARTimings{
+		DNS:     harSpan(timer.dnsStart, timer.dnsDone),
+		Connect: harSpan(timer.connectStart, connectEnd),
+		SSL:     harSpan(timer.tlsStart, timer.tlsDone),
+		Send:    harSpan(timer.gotConn, timer.wrote),
+		Wait:    harSpan(timer.wrote, timer.firstByte),
+		Receive: harSpan(timer.firstByte, end),
+	}
+
+	// send, wait, receive 不能为-1，例如缓存命中的尝试
+	for _, d := range []*float64{&timings.Send, &timings.Wait, &timings.Receive} {
+		if *d < 0 {
+			*d = 0
+		}
+	}
+
+	// 等待连接的时间中去掉解析与建立连接
+	timings.Blocked = harSpan(timer.getConn, timer.gotConn)
+	if timings.Blocked >= 0 {
+		for _, d := range []float64{timings.DNS, timings.Connect} {
+			if d > 0 {
+				timings.Blocked -= d
+			}
+		}
+		if timings.Blocked < 0 {
+			timings.Blocked = 0
+		}
+	}
+
+	total := 0.0
+	for _, d := range []float64{timings.Blocked, timings.DNS, timings.Connect, timings.Send, timings.Wait, timings.Receive} {
+		if d > 0 {
+			total += d
+		}
+	}
+	// 缓存等没有经过网络的尝试
+	if total == 0 {
+		total = harSpan(timer.start, end)
+	}
+	return timings, total
+}
+
+// start 为一次尝试挂载httptrace，返回新的请求以及结束回调
+func (recorder *HARRecorder) start(req *http.Request) (*http.Request, func(*http.Response, error)) {
+	timer := &harTimer{start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timer.trace()))
+
+	return req, func(rsp *http.Response, err error) {
+		if err != nil || rsp == nil || rsp.Body == nil {
+			recorder.add(timer, req, rsp, err, nil)
+			return
+		}
+		rsp.Body = &harBody{ReadCloser: rsp.Body, limit: recorder.maxBodySize, done: func(body *harBody) {
+			recorder.add(timer, req, rsp, nil, body)
+		}}
+	}
+}
+
+func (recorder *HARRecorder) add(timer *harTimer, req *http.Request, rsp *http.Response, err error, body *harBody) {
+	timings, total := timer.timings(time.Now())
+	entry := HAREntry{
+		StartedDateTime: timer.start,
+		Time:            total,
+		Request:         recorder.request(req),
+		Timings:         timings,
+		ServerIPAddress: timer.remoteAddr,
+		Response: HARResponse{
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if rsp != nil {
+		entry.Request.HTTPVersion = rsp.Proto
+		entry.Response = recorder.response(rsp, body)
+	}
+
+	recorder.mutex.Lock()
+	recorder.entries = append(recorder.entries, entry)
+	recorder.mutex.Unlock()
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	list := []HARNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			list = append(list, HARNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (recorder *HARRecorder) request(req *http.Request) HARRequest {
+	policy := recorder.policy
+	header := policy.redactHeader(req.Header)
+	header.Set("Host", req.Host)
+
+	harReq := HARRequest{
+		Method:      req.Method,
+		URL:         policy.redactURL(req.URL),
+		HTTPVersion: req.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+
+	query := req.URL.Query()
+	for name, values := range query {
+		for _, value := range values {
+			if matchName(policy.queries, name) {
+				value = LogRedacted
+			}
+			harReq.QueryString = append(harReq.QueryString, HARNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(harReq.QueryString, func(i, j int) bool { return harReq.QueryString[i].Name < harReq.QueryString[j].Name })
+
+	info := invokeInfoFrom(req)
+	switch {
+	case info == nil:
+	case info.streamed:
+		harReq.BodySize = req.ContentLength
+		harReq.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type")}
+	case len(info.payload) > 0:
+		harReq.BodySize = int64(len(info.payload))
+		if info.encoding != "" {
+			harReq.BodySize = int64(info.encodedSize)
+		}
+		// 无法脱敏时不记录内容
+		harReq.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type")}
+		if redacted, ok := policy.redactBody(req.Header.Get("Content-Type"), info.payload); ok {
+			harReq.PostData.Text = string(redacted)
+		}
+	}
+	return harReq
+}
+
+func (recorder *HARRecorder) response(rsp *http.Response, body *harBody) HARResponse {
+	harRsp := HARResponse{
+		Status:      rsp.StatusCode,
+		StatusText:  http.StatusText(rsp.StatusCode),
+		HTTPVersion: rsp.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(recorder.policy.redactHeader(rsp.Header)),
+		RedirectURL: rsp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+		Content:     HARContent{MimeType: rsp.Header.Get("Content-Type")},
+	}
+	if body == nil {
+		return harRsp
+	}
+
+	harRsp.Content.Size = body.size
+	harRsp.BodySize = body.size
+	if decoded, ok := body.ReadCloser.(*decodedBody); ok {
+		harRsp.BodySize = decoded.counter.n
+		harRsp.Content.Compression = body.size - decoded.counter.n
+	}
+
+	text := body.buffer.Bytes()
+	truncated := body.size > int64(len(text))
+	if !utf8.Valid(text) {
+		harRsp.Content.Text = base64.StdEncoding.EncodeToString(text)
+		harRsp.Content.Encoding = "base64"
+	} else if redacted, ok := recorder.policy.redactBody(harRsp.Content.MimeType, text); ok {
+		harRsp.Content.Text = string(redacted)
+	} else {
+		// 截断后的json无法脱敏，不记录内容
+		harRsp.Content.Comment = "omitted, cannot be redacted"
+		return harRsp
+	}
+	if truncated {
+		harRsp.Content.Comment = "truncated"
+	}
+	return harRsp
+}
+
+// harBody 记录读取的响应体，读取完或关闭时生成记录
+type harBody struct {
+	io.ReadCloser
+	buffer bytes.Buffer
+	limit  int
+	size   int64
+	once   sync.Once
+	done   func(body *harBody)
+}
+
+func (body *harBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.size += int64(n)
+	if remain := body.limit - body.buffer.Len(); remain > 0 {
+		if remain > n {
+			remain = n
+		}
+		body.buffer.Write(p[:remain])
+	}
+	if err == io.EOF {
+		body.once.Do(func() { body.done(body) })
+	}
+	return n, err
+}
+
+func (body *harBody) Close() error {
+	body.once.Do(func() { body.done(body) })
+	return body.ReadCloser.Close()
+}
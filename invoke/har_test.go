@@ -0,0 +1,106 @@
+package invoke
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHARRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result":true,"token":"leak"}`))
+	}))
+	defer server.Close()
+
+	recorder := NewHARRecorder()
+	template := Addr(strings.TrimPrefix(server.URL, "http://")).Record(recorder).Template()
+
+	rsp, err := template.Call(context.Background()).Post("/login").Query("apiKey", "k").Json(map[string]string{"user": "u", "password": "p"}).Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+
+	if _, err := Addr("127.0.0.1:1").Record(recorder).Get("/down").Request(); err == nil {
+		t.Fatal("expect connection error")
+	}
+
+	path := filepath.Join(t.TempDir(), "session.har")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var har HAR
+	if err := json.Unmarshal(b, &har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("unexpected har %s", b)
+	}
+
+	entry := har.Log.Entries[0]
+	if entry.Request.Method != "POST" || !strings.Contains(entry.Request.URL, "apiKey=REDACTED") {
+		t.Fatalf("unexpected request %+v", entry.Request)
+	}
+	if entry.Request.PostData == nil || strings.Contains(entry.Request.PostData.Text, `"p"`) {
+		t.Fatalf("post data not redacted %+v", entry.Request.PostData)
+	}
+	if entry.Response.Status != 200 || entry.Response.Content.Text != `{"result":true,"token":"REDACTED"}` {
+		t.Fatalf("unexpected response %+v", entry.Response)
+	}
+	if entry.Timings.Connect < 0 || entry.Timings.Wait < 0 || entry.Time <= 0 || entry.ServerIPAddress != "127.0.0.1" {
+		t.Fatalf("unexpected timings %+v %v %s", entry.Timings, entry.Time, entry.ServerIPAddress)
+	}
+
+	if failed := har.Log.Entries[1]; failed.Error == "" || failed.Response.Status != 0 {
+		t.Fatalf("failed attempt not recorded %+v", failed)
+	}
+}
+
+func TestHARRecorderTruncatedBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			w.Write([]byte(strings.Repeat("x", 64)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token":"leak","detail":"` + strings.Repeat("x", 64) + `"}`))
+	}))
+	defer server.Close()
+
+	recorder := NewHARRecorder().MaxBodySize(16)
+	for _, path := range []string{"/json", "/text"} {
+		rsp, err := Addr(strings.TrimPrefix(server.URL, "http://")).Get(path).Record(recorder).Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+	}
+
+	entries := recorder.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	// 截断后的json无法脱敏，不记录内容
+	content := entries[0].Response.Content
+	if strings.Contains(content.Text, "leak") || content.Text != "" || content.Comment == "" || content.Size <= 16 {
+		t.Fatalf("truncated json should be omitted %+v", content)
+	}
+	if content := entries[1].Response.Content; content.Text != strings.Repeat("x", 16) || content.Comment != "truncated" {
+		t.Fatalf("unexpected text content %+v", content)
+	}
+}
@@ -0,0 +1,237 @@
+package invoke
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const hedgeSampleSize = 1024
+
+// HedgePolicy 对冲请求策略：请求在delay内没有返回时，向另一个地址(或同一地址)再发一次，取先成功的结果
+// 同一个策略可以被多个invoker共享，观测的延迟与额外流量按策略统计
+// 默认只对冲GET，HEAD与OPTIONS请求，其他方法的请求不对冲
+type HedgePolicy struct {
+	fixed      time.Duration
+	percentile float64
+	maxPercent float64
+	anyMethod  bool
+
+	total  int64
+	hedged int64
+
+	mutex   sync.Mutex
+	samples []time.Duration
+	next    int
+	dirty   int
+	cached  time.Duration
+}
+
+// NewHedgePolicy 使用固定的对冲延迟，使用 Percentile 时作为样本不足时的延迟
+func NewHedgePolicy(delay time.Duration) *HedgePolicy {
+	return &HedgePolicy{
+		fixed:      delay,
+		maxPercent: 10,
+		samples:    make([]time.Duration, 0, hedgeSampleSize),
+	}
+}
+
+// Percentile 使用观测到的延迟的p分位(0-100)作为对冲延迟
+func (policy *HedgePolicy) Percentile(p float64) *HedgePolicy {
+	policy.percentile = p
+	return policy
+}
+
+// MaxPercent 对冲请求最多占总请求数的百分比，默认10
+func (policy *HedgePolicy) MaxPercent(percent float64) *HedgePolicy {
+	policy.maxPercent = percent
+	return policy
+}
+
+// AnyMethod 允许对冲任意方法的请求，服务端可能处理两次，只用于幂等的请求，例如带幂等键的POST
+func (policy *HedgePolicy) AnyMethod() *HedgePolicy {
+	policy.anyMethod = true
+	return policy
+}
+
+// allowMethod 请求方法是否可以对冲
+func (policy *HedgePolicy) allowMethod(method string) bool {
+	return policy.anyMethod || idempotentMethod(method)
+}
+
+// Hedged 已发出的对冲请求数与总请求数
+func (policy *HedgePolicy) Hedged() (hedged int64, total int64) {
+	return atomic.LoadInt64(&policy.hedged), atomic.LoadInt64(&policy.total)
+}
+
+func (policy *HedgePolicy) delay() time.Duration {
+	if policy.percentile <= 0 {
+		return policy.fixed
+	}
+
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	if len(policy.samples) < 20 {
+		return policy.fixed
+	}
+
+	if policy.cached == 0 || policy.dirty >= 50 {
+		sorted := make([]time.Duration, len(policy.samples))
+		copy(sorted, policy.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		index := int(float64(len(sorted)-1) * policy.percentile / 100)
+		policy.cached = sorted[index]
+		policy.dirty = 0
+	}
+	return policy.cached
+}
+
+func (policy *HedgePolicy) observe(d time.Duration) {
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	if len(policy.samples) < hedgeSampleSize {
+		policy.samples = append(policy.samples, d)
+	} else {
+		policy.samples[policy.next] = d
+		policy.next = (policy.next + 1) % hedgeSampleSize
+	}
+	policy.dirty++
+}
+
+// allow 额外流量未超出限制时占用一个对冲名额
+func (policy *HedgePolicy) allow() bool {
+	for {
+		hedged := atomic.LoadInt64(&policy.hedged)
+		total := atomic.LoadInt64(&policy.total)
+		if float64(hedged+1) > float64(total)*policy.maxPercent/100 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&policy.hedged, hedged, hedged+1) {
+			return true
+		}
+	}
+}
+
+// Hedge 启用对冲请求，默认只对冲只读的请求，见 HedgePolicy.AnyMethod
+func (invoker *Invoker) Hedge(policy *HedgePolicy) *Invoker {
+	invoker.hedge = policy
+	return invoker
+}
+
+type hedgeResult struct {
+	req    *http.Request
+	rsp    *http.Response
+	err    error
+	cost   time.Duration
+	index  int
+	cancel context.CancelFunc
+}
+
+func (result *hedgeResult) ok() bool {
+	return result.req != nil && result.err == nil && result.rsp.StatusCode < 500
+}
+
+func (result *hedgeResult) discard() {
+	if result.rsp != nil && result.rsp.Body != nil {
+		result.rsp.Body.Close()
+	}
+	result.cancel()
+}
+
+// hedgedSend 发起一次尝试，超过对冲延迟未返回时再发起一次，返回先成功的结果并取消另一个
+func (invoker *Invoker) hedgedSend(ctx context.Context, proto *http.Request, n int, body []byte, tried *triedEndpoints) (*http.Request, *http.Response, error) {
+	policy := invoker.hedge
+	atomic.AddInt64(&policy.total, 1)
+
+	results := make(chan *hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func() {
+		ctx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			req, rsp, err := invoker.send(ctx, proto, n, body, tried)
+			results <- &hedgeResult{req, rsp, err, time.Since(start), index, cancel}
+		}()
+	}
+
+	launch()
+	launched, pending := 1, 1
+
+	timer := time.NewTimer(policy.delay())
+	defer timer.Stop()
+
+	var fallback *hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if launched == 1 && policy.allow() {
+				launch()
+				launched++
+				pending++
+			}
+
+		case result := <-results:
+			pending--
+			if result.req != nil {
+				policy.observe(result.cost)
+			}
+
+			if !result.ok() {
+				if fallback != nil {
+					fallback.discard()
+				}
+				fallback = result
+				continue
+			}
+
+			if fallback != nil {
+				fallback.discard()
+			}
+
+			// 取消仍在进行的请求
+			if pending > 0 {
+				for index, cancel := range cancels {
+					if index != result.index {
+						cancel()
+					}
+				}
+				go func(pending int) {
+					for i := 0; i < pending; i++ {
+						(<-results).discard()
+					}
+				}(pending)
+			}
+
+			result.rsp.Body = &cancelOnClose{ReadCloser: result.rsp.Body, cancel: result.cancel}
+			return result.req, result.rsp, result.err
+		}
+	}
+
+	if fallback.rsp != nil && fallback.rsp.Body != nil {
+		fallback.rsp.Body = &cancelOnClose{ReadCloser: fallback.rsp.Body, cancel: fallback.cancel}
+	} else {
+		fallback.cancel()
+	}
+	return fallback.req, fallback.rsp, fallback.err
+}
+
+// cancelOnClose 关闭响应体时释放请求的context
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
@@ -0,0 +1,76 @@
+package invoke
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedge(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	upstream := NewUpstream(strings.TrimPrefix(slow.URL, "http://"), strings.TrimPrefix(fast.URL, "http://"))
+	policy := NewHedgePolicy(20 * time.Millisecond).MaxPercent(100)
+
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		rsp, err := New().Upstream(upstream).Hedge(policy).Get("/").Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+
+		if cost := time.Since(start); cost > time.Second {
+			t.Fatalf("hedged request cost %v", cost)
+		}
+	}
+
+	if hedged, total := policy.Hedged(); hedged == 0 || total != 4 {
+		t.Fatalf("hedged=%d total=%d", hedged, total)
+	}
+}
+
+func TestHedgeMethod(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	policy := NewHedgePolicy(10 * time.Millisecond).MaxPercent(100)
+
+	if _, err := Addr(addr).Post("/orders").Payload([]byte("{}")).Hedge(policy).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&hits); n != 1 {
+		t.Fatalf("POST should not be hedged, hits=%d", n)
+	}
+	if hedged, total := policy.Hedged(); hedged != 0 || total != 0 {
+		t.Fatalf("hedged=%d total=%d", hedged, total)
+	}
+
+	atomic.StoreInt64(&hits, 0)
+	policy.AnyMethod()
+	if _, err := Addr(addr).Post("/orders").Payload([]byte("{}")).Hedge(policy).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&hits); n != 2 {
+		t.Fatalf("POST should be hedged after AnyMethod, hits=%d", n)
+	}
+}
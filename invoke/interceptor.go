@@ -0,0 +1,105 @@
+package invoke
+
+import (
+	"net/http"
+)
+
+// Handler 处理一次调用的请求
+type Handler func(req *http.Request) (*http.Response, error)
+
+// Interceptor 拦截器，包装下一个 Handler，可以修改请求，不调用next直接返回响应，多次调用next重试，或者统计耗时
+//
+//	invoker.Intercept(func(next invoke.Handler) invoke.Handler {
+//		return func(req *http.Request) (*http.Response, error) {
+//			start := time.Now()
+//			rsp, err := next(req)
+//			log.Println(req.URL.Path, time.Since(start))
+//			return rsp, err
+//		}
+//	})
+//
+// 一次调用从外到内依次经过:
+//
+//	AfterRequest -> CheckStatus/OnlyStatus200 -> Intercept添加的拦截器(先添加的在外) -> BeforeRequest -> 发送
+//
+// 发送阶段按 Retry, Hedge 与 Upstream 对请求的副本进行一次或多次尝试，每次尝试替换地址并签名，
+// 因此拦截器看到的是整个调用的请求，其中的地址为 Addr 的值
+type Interceptor func(next Handler) Handler
+
+// Intercept 追加拦截器，模板上添加的拦截器在调用时添加的拦截器之外
+func (invoker *Invoker) Intercept(interceptors ...Interceptor) *Invoker {
+	list := make([]Interceptor, 0, len(invoker.interceptors)+len(interceptors))
+	list = append(list, invoker.interceptors...)
+	invoker.interceptors = append(list, interceptors...)
+	return invoker
+}
+
+// BeforeRequest 发送前修改请求，只保留最后设置的函数，需要多个时使用 Intercept
+func (invoker *Invoker) BeforeRequest(fn func(req *http.Request)) *Invoker {
+	invoker.beforeRequest = fn
+	return invoker
+}
+
+// AfterRequest 调用结束后依次处理响应，返回的错误作为调用的结果，
+// 每次调用替换之前设置的函数，需要叠加时使用 Intercept
+func (invoker *Invoker) AfterRequest(fnList ...func(interface{}, *http.Response, error) error) *Invoker {
+	invoker.afterRequests = fnList
+	return invoker
+}
+
+// handler 按顺序组装本次调用的拦截器
+func (invoker *Invoker) handler(body []byte, last **http.Request, statusFailed *bool) Handler {
+	handler := invoker.transport(body, last)
+
+	if fn := invoker.beforeRequest; fn != nil {
+		handler = beforeRequestInterceptor(fn)(handler)
+	}
+
+	for i := len(invoker.interceptors) - 1; i >= 0; i-- {
+		handler = invoker.interceptors[i](handler)
+	}
+
+	if check := invoker.parseStatus; check != nil {
+		handler = checkStatusInterceptor(check, statusFailed)(handler)
+	}
+
+	if len(invoker.afterRequests) > 0 {
+		handler = afterRequestInterceptor(invoker.afterRequests, invoker.userData)(handler)
+	}
+
+	return handler
+}
+
+func beforeRequestInterceptor(fn func(req *http.Request)) Interceptor {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			fn(req)
+			return next(req)
+		}
+	}
+}
+
+func checkStatusInterceptor(check func(rsp *http.Response) error, failed *bool) Interceptor {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			rsp, err := next(req)
+			if rsp != nil {
+				err = check(rsp)
+				*failed = err != nil
+			}
+			return rsp, err
+		}
+	}
+}
+
+func afterRequestInterceptor(fnList []func(interface{}, *http.Response, error) error, userData interface{}) Interceptor {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			rsp, err := next(req)
+			for _, fn := range fnList {
+				err = fn(userData, rsp, err)
+			}
+			return rsp, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package invoke
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func recordInterceptor(name string, order *[]string) Interceptor {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			*order = append(*order, name)
+			return next(req)
+		}
+	}
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Intercepted")))
+	}))
+	defer server.Close()
+
+	var order []string
+	template := Addr(strings.TrimPrefix(server.URL, "http://")).
+		Intercept(recordInterceptor("template", &order)).
+		BeforeRequest(func(req *http.Request) {
+			order = append(order, "before")
+			req.Header.Set("X-Intercepted", "1")
+		}).
+		AfterRequest(func(data interface{}, rsp *http.Response, err error) error {
+			order = append(order, "after")
+			return err
+		}).
+		OnlyStatus200().
+		Template()
+
+	rsp, err := template.Call(context.Background()).Get("/").Intercept(recordInterceptor("call", &order)).Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	b, _ := ioutil.ReadAll(rsp.Body)
+	if string(b) != "1" {
+		t.Fatalf("request not modified, got %q", b)
+	}
+	if strings.Join(order, ",") != "template,call,before,after" {
+		t.Fatalf("unexpected order %v", order)
+	}
+}
+
+func TestInterceptorShortCircuitAndRetry(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) != "payload" {
+			t.Errorf("unexpected body %q", b)
+		}
+		if atomic.AddInt64(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	shortCircuit := func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusTeapot, Body: http.NoBody, Request: req}, nil
+		}
+	}
+	rsp, err := Addr(addr).Post("/").Payload([]byte("payload")).Intercept(shortCircuit).Request()
+	if err != nil || rsp.StatusCode != http.StatusTeapot || atomic.LoadInt64(&hits) != 0 {
+		t.Fatalf("expect short circuit, got %v %v", rsp, err)
+	}
+
+	retryOn500 := func(next Handler) Handler {
+		return func(req *http.Request) (*http.Response, error) {
+			rsp, err := next(req)
+			if err == nil && rsp.StatusCode == http.StatusInternalServerError {
+				rsp.Body.Close()
+				return next(req)
+			}
+			return rsp, err
+		}
+	}
+	rsp, err = Addr(addr).Post("/").Payload([]byte("payload")).Intercept(retryOn500).OnlyStatus200().Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if n := atomic.LoadInt64(&hits); n != 2 {
+		t.Fatalf("expect 2 upstream calls, got %d", n)
+	}
+}
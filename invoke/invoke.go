@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 type TemporaryError interface {
@@ -66,10 +69,31 @@ type Invoker struct {
 	logger                func(time.Duration, *http.Request, *http.Response, error)
 	closeBodyAfterRequest bool
 	parseStatus           func(*http.Response) error
-
-	// field bellow will not copy
-	ctx      context.Context
-	userData interface{}
+	upstream              *Upstream
+	balanceKey            string
+	retry                 int
+	retryOn               func(*http.Response, error) bool
+	retryAnyMethod        bool
+	signer                Signer
+	encoding              string
+	compressMinSize       int
+	stream                *streamBody
+	observers             []Observer
+	hedge                 *HedgePolicy
+	coalescer             *Coalescer
+	cache                 *Cache
+	rateLimiter           *RateLimiter
+	requestWeight         int
+	logPolicy             *LogPolicy
+	pathParams            map[string]string
+	discovery             *Discovery
+	tlsConfig             *TLSConfig
+	recorder              *HARRecorder
+	interceptors          []Interceptor
+	closeOnStatusError    bool
+	customLogger          bool
+	ctx                   context.Context
+	userData              interface{}
 }
 
 type InvokeError struct {
@@ -119,9 +143,44 @@ func Copy(invoker *Invoker) *Invoker {
 		doRequest:             invoker.doRequest,
 		closeBodyAfterRequest: invoker.closeBodyAfterRequest,
 		parseStatus:           invoker.parseStatus,
+		upstream:              invoker.upstream,
+		balanceKey:            invoker.balanceKey,
+		retry:                 invoker.retry,
+		retryOn:               invoker.retryOn,
+		retryAnyMethod:        invoker.retryAnyMethod,
+		signer:                invoker.signer,
+		encoding:              invoker.encoding,
+		compressMinSize:       invoker.compressMinSize,
+		stream:                invoker.stream,
+		observers:             invoker.observers,
+		hedge:                 invoker.hedge,
+		coalescer:             invoker.coalescer,
+		cache:                 invoker.cache,
+		rateLimiter:           invoker.rateLimiter,
+		requestWeight:         invoker.requestWeight,
+		logPolicy:             invoker.logPolicy,
+		discovery:             invoker.discovery,
+		tlsConfig:             invoker.tlsConfig,
+		recorder:              invoker.recorder,
+		interceptors:          invoker.interceptors,
+		closeOnStatusError:    invoker.closeOnStatusError,
+		ctx:                   invoker.ctx,
+		userData:              invoker.userData,
 	}
 
-	in.logger = in.debugLogger
+	if invoker.customLogger {
+		in.logger = invoker.logger
+		in.customLogger = true
+	} else {
+		in.logger = in.debugLogger
+	}
+
+	for key, value := range invoker.pathParams {
+		if in.pathParams == nil {
+			in.pathParams = make(map[string]string, len(invoker.pathParams))
+		}
+		in.pathParams[key] = value
+	}
 
 	for key, value := range invoker.queries {
 		if in.queries == nil {
@@ -147,65 +206,79 @@ func (invoker *Invoker) Copy() *Invoker {
 	return Copy(invoker)
 }
 
-func (invoker *Invoker) debugLogger(d time.Duration, req *http.Request, rsp *http.Response, err error) {
-	func() {
-		payload := []byte{}
-		if invoker.payload != nil {
-			payload, _ = invoker.payload()
-		}
+type invokeInfoKey struct{}
 
-		if err != nil {
-			if req == nil {
-				logrus.WithError(err).Errorln("InvokeFailed")
-				return
-			}
-
-			logrus.WithFields(logrus.Fields{
-				"cost":    d,
-				"method":  req.Method,
-				"url":     req.URL.String(),
-				"error":   err,
-				"header":  req.Header,
-				"payload": string(payload),
-			}).Errorln("InvokeFailed")
-
-			return
-		}
+// invokeInfo 一次调用过程中产生的信息，通过请求的context传递给日志
+type invokeInfo struct {
+	payload     []byte
+	streamed    bool
+	payloadSize int
+	encodedSize int
+	encoding    string
+	cache       int32
+	path        string // 替换参数后的路径
+	route       string // 路径模板，作为日志与监控中的路由
+}
 
-		statusCode := 0
-		if rsp != nil {
-			statusCode = rsp.StatusCode
-		}
+func withInvokeInfo(ctx context.Context, info *invokeInfo) context.Context {
+	return context.WithValue(ctx, invokeInfoKey{}, info)
+}
 
-		if statusCode != 200 {
-			logrus.WithFields(logrus.Fields{
-				"cost":    d,
-				"method":  req.Method,
-				"url":     req.URL.String(),
-				"header":  req.Header,
-				"error":   fmt.Sprintf("status=%d", statusCode),
-				"status":  statusCode,
-				"payload": string(payload),
-			}).Errorln("InvokeFailed")
-
-		} else {
-			logrus.WithFields(logrus.Fields{
-				"cost":    d,
-				"method":  req.Method,
-				"url":     req.URL.String(),
-				"header":  req.Header,
-				"status":  statusCode,
-				"payload": string(payload),
-			}).Infoln("InvokeDone")
-		}
-	}()
+func invokeInfoFrom(req *http.Request) *invokeInfo {
+	if req == nil {
+		return nil
+	}
+	info, _ := req.Context().Value(invokeInfoKey{}).(*invokeInfo)
+	return info
 }
 
+// Addr 目标地址，host:port，service://名称 或者 unix:///path/to.sock 等 RegisterDialer 注册的地址
 func (invoker *Invoker) Addr(addr string) *Invoker {
 	invoker.addr = addr
 	return invoker
 }
 
+// ServiceName 服务名，service://名称 的地址为名称，其他为 Addr 设置的地址
+func (invoker *Invoker) ServiceName() string {
+	return strings.TrimPrefix(invoker.addr, ServicePrefix)
+}
+
+// Addrs 使用多个地址，每次请求由负载均衡器挑选其中一个
+func (invoker *Invoker) Addrs(addrs ...string) *Invoker {
+	return invoker.Upstream(NewUpstream(addrs...))
+}
+
+// Upstream 使用地址集，设置后 Addr 将被忽略
+func (invoker *Invoker) Upstream(upstream *Upstream) *Invoker {
+	invoker.upstream = upstream
+	return invoker
+}
+
+// BalanceKey 一致性哈希等负载均衡器使用的请求键
+func (invoker *Invoker) BalanceKey(key string) *Invoker {
+	invoker.balanceKey = key
+	return invoker
+}
+
+// Retry 失败后最多重试n次，使用地址集时重试会尽量选择不同的地址
+// 默认只重试GET,HEAD与OPTIONS请求，其他方法需要 RetryAnyMethod
+func (invoker *Invoker) Retry(n int) *Invoker {
+	invoker.retry = n
+	return invoker
+}
+
+// RetryOn 自定义是否需要重试，默认网络错误以及502/503/504时重试
+func (invoker *Invoker) RetryOn(fn func(*http.Response, error) bool) *Invoker {
+	invoker.retryOn = fn
+	return invoker
+}
+
+// RetryAnyMethod 允许重试任意方法的请求，服务端可能处理多次，只用于幂等的请求，例如带幂等键的POST
+func (invoker *Invoker) RetryAnyMethod() *Invoker {
+	invoker.retryAnyMethod = true
+	return invoker
+}
+
 func (invoker *Invoker) Method(method, path string) *Invoker {
 	invoker.method = method
 	invoker.path = path
@@ -242,6 +315,7 @@ func (invoker *Invoker) Timestamp() *Invoker {
 	return invoker.Query("timestamp", time.Now().UnixNano()/int64(time.Millisecond))
 }
 
+// Header 设置请求头，Host 用于覆盖默认的Host请求头
 func (invoker *Invoker) Header(key, value string) *Invoker {
 	if invoker.headers == nil {
 		invoker.headers = make(map[string]string)
@@ -256,6 +330,7 @@ func (invoker *Invoker) HeaderValue(key string, value interface{}) *Invoker {
 }
 
 func (invoker *Invoker) Payload(body []byte) *Invoker {
+	invoker.stream = nil
 	invoker.payload = func() ([]byte, error) {
 		return body, nil
 	}
@@ -269,6 +344,7 @@ func (invoker *Invoker) Json(v interface{}) *Invoker {
 		err = &InvokeError{"MarshalJson", err, false, false}
 	}
 
+	invoker.stream = nil
 	invoker.payload = func() ([]byte, error) {
 		return b, err
 	}
@@ -286,10 +362,18 @@ func (invoker *Invoker) TLS() *Invoker {
 	return invoker
 }
 
+// Encoding 使用codec压缩请求体并设置 Content-Encoding，codec需要已注册，见 RegisterCodec
 func (invoker *Invoker) Encoding(codec string) *Invoker {
+	invoker.encoding = codec
 	return invoker.Header("Content-Encoding", codec)
 }
 
+// CompressMinSize 请求体小于size字节时不压缩
+func (invoker *Invoker) CompressMinSize(size int) *Invoker {
+	invoker.compressMinSize = size
+	return invoker
+}
+
 func (invoker *Invoker) EncodingGzip() *Invoker {
 	return invoker.Encoding("gzip")
 }
@@ -319,7 +403,6 @@ func (invoker *Invoker) OnlyStatus200() *Invoker {
 	invoker.CheckStatus(
 		func(rsp *http.Response) error {
 			if rsp.StatusCode != 200 {
-				invoker.closeBodyAfterRequest = true
 				return &InvokeError{
 					Action: "ParseStatus",
 					Err:    fmt.Errorf(rsp.Status),
@@ -328,16 +411,19 @@ func (invoker *Invoker) OnlyStatus200() *Invoker {
 			return nil
 		},
 	)
+	invoker.closeOnStatusError = true
 	return invoker
 }
 
 func (invoker *Invoker) CheckStatus(check func(rsp *http.Response) error) *Invoker {
 	invoker.parseStatus = check
+	invoker.closeOnStatusError = false
 	return invoker
 }
 
 func (invoker *Invoker) Logger(f func(time.Duration, *http.Request, *http.Response, error)) *Invoker {
 	invoker.logger = f
+	invoker.customLogger = true
 	return invoker
 }
 
@@ -346,26 +432,67 @@ func (invoker *Invoker) Context(ctx context.Context) *Invoker {
 	return invoker
 }
 
-func (invoker *Invoker) BeforeRequest(fn func(req *http.Request)) *Invoker {
-	invoker.beforeRequest = fn
+// Signer 在 BeforeRequest 之后，发送之前对请求签名
+func (invoker *Invoker) Signer(signer Signer) *Invoker {
+	invoker.signer = signer
 	return invoker
 }
 
+// Request 发起请求，调用在invoker的副本上进行，不会修改invoker，
+// 因此配置完成的invoker可以被多个goroutine同时调用 Request，但不能同时修改其配置，参考 Template
 func (invoker *Invoker) Request() (*http.Response, error) {
+	call := *invoker
+	return call.request()
+}
+
+func (invoker *Invoker) request() (*http.Response, error) {
 	now := time.Now()
 
-	reader := &bytes.Reader{}
-	var err error
+	var body []byte
+	info := &invokeInfo{streamed: invoker.stream != nil, route: invoker.path, path: invoker.path}
+
+	// 没有设置路径参数时原样使用路径，其中的括号不作为模板
+	if len(invoker.pathParams) > 0 {
+		path, err := expandPath(invoker.path, invoker.pathParams)
+		if err != nil {
+			if invoker.logger != nil {
+				invoker.logger(0, nil, nil, err)
+			}
+			return nil, err
+		}
+		info.path = path
+	}
 
 	if invoker.payload != nil {
-		body, err := invoker.payload()
+		var err error
+		body, err = invoker.payload()
+		if err == nil {
+			info.payload = body
+			info.payloadSize = len(body)
+			var compressed bool
+			body, compressed, err = invoker.compressPayload(body)
+			if compressed {
+				info.encoding = invoker.encoding
+				info.encodedSize = len(body)
+			}
+		}
 		if err != nil {
 			if invoker.logger != nil {
 				invoker.logger(0, nil, nil, err)
 			}
 			return nil, err
 		}
-		reader = bytes.NewReader(body)
+	}
+
+	if invoker.client == nil && invoker.tlsConfig != nil {
+		client, err := invoker.tlsConfig.httpClient()
+		if err != nil {
+			if invoker.logger != nil {
+				invoker.logger(0, nil, nil, err)
+			}
+			return nil, err
+		}
+		invoker.client = client
 	}
 
 	if invoker.client == nil {
@@ -381,41 +508,239 @@ func (invoker *Invoker) Request() (*http.Response, error) {
 		invoker.ctx, cancelFunc = context.WithTimeout(invoker.ctx, invoker.timeout)
 		defer cancelFunc()
 	}
+	invoker.ctx = withInvokeInfo(invoker.ctx, info)
 	if invoker.scheme == "" {
 		invoker.scheme = "http"
 	}
 
-	urlString, _ := makeUrl(invoker.scheme, invoker.addr, invoker.path, invoker.queries)
-	req, err := http.NewRequestWithContext(invoker.ctx, invoker.method, urlString, reader)
+	if invoker.doRequest == nil {
+		invoker.doRequest = DefaultDoRequest
+	}
+
+	proto, err := invoker.newRequest(invoker.ctx, body, info)
+	if err != nil {
+		if invoker.logger != nil {
+			invoker.logger(0, nil, nil, err)
+		}
+		return nil, err
+	}
+
+	var (
+		last         *http.Request // 最后一次尝试的请求，用于日志
+		statusFailed bool
+	)
+	handler := invoker.handler(body, &last, &statusFailed)
+	rsp, err := handler(proto)
+
+	if rsp != nil && invoker.parseStatus != nil && (invoker.closeBodyAfterRequest || (statusFailed && invoker.closeOnStatusError)) && rsp.Body != nil {
+		defer rsp.Body.Close()
+	}
+
+	if last == nil {
+		last = proto
+	}
+	if invoker.logger != nil {
+		invoker.logger(time.Since(now), last, rsp, err)
+	}
+
+	return rsp, err
+}
+
+// newRequest 构建本次调用的请求，每次尝试在其副本上替换地址
+func (invoker *Invoker) newRequest(ctx context.Context, body []byte, info *invokeInfo) (*http.Request, error) {
+	host := targetHost(strings.TrimPrefix(invoker.addr, ServicePrefix))
+	urlString, _ := makeUrl(invoker.scheme, host, info.path, invoker.queries)
+
+	var reader io.Reader
+	if invoker.stream == nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, invoker.method, urlString, reader)
 	if err != nil {
 		return nil, &InvokeError{"BuildRequest", err, false, false}
 	}
 
+	req.Host = hostHeader(host)
 	for key, value := range invoker.headers {
 		req.Header.Set(key, value)
 	}
+	if value := req.Header.Get("Host"); value != "" {
+		req.Host = value
+		req.Header.Del("Host")
+	}
 
-	if err != nil {
-		if invoker.logger != nil {
-			invoker.logger(0, nil, nil, err)
+	// 请求体未达到压缩阈值
+	if invoker.encoding != "" && info.encoding == "" {
+		req.Header.Del("Content-Encoding")
+	}
+	return req, nil
+}
+
+// transport 按重试,对冲策略发起一次或多次尝试
+func (invoker *Invoker) transport(body []byte, last **http.Request) Handler {
+	retryOn := invoker.retryOn
+	if retryOn == nil {
+		retryOn = defaultRetryOn
+	}
+
+	return func(proto *http.Request) (*http.Response, error) {
+		ctx := proto.Context()
+
+		var (
+			req   *http.Request
+			rsp   *http.Response
+			err   error
+			tried = &triedEndpoints{}
+		)
+
+		for attempt := 0; attempt <= invoker.retry; attempt++ {
+			if attempt > 0 && rsp != nil && rsp.Body != nil {
+				rsp.Body.Close()
+			}
+
+			if invoker.hedge != nil && invoker.hedge.allowMethod(proto.Method) && (invoker.stream == nil || invoker.stream.replayable) {
+				req, rsp, err = invoker.hedgedSend(ctx, proto, attempt, body, tried)
+			} else {
+				req, rsp, err = invoker.send(ctx, proto, attempt, body, tried)
+			}
+			if req == nil {
+				return nil, err
+			}
+			*last = req
+
+			if ctx.Err() != nil || !(invoker.retryAnyMethod || idempotentMethod(proto.Method)) || !retryOn(rsp, err) {
+				break
+			}
+
+			if invoker.stream != nil && !invoker.stream.replayable {
+				break
+			}
 		}
-		return nil, err
+
+		return rsp, err
 	}
+}
+
+// triedEndpoints 本次调用已经尝试过的地址
+type triedEndpoints struct {
+	mutex     sync.Mutex
+	endpoints []*Endpoint
+}
 
-	if invoker.beforeRequest != nil {
-		invoker.beforeRequest(req)
+func (tried *triedEndpoints) list() []*Endpoint {
+	tried.mutex.Lock()
+	defer tried.mutex.Unlock()
+
+	return tried.endpoints
+}
+
+func (tried *triedEndpoints) add(ep *Endpoint) {
+	tried.mutex.Lock()
+	defer tried.mutex.Unlock()
+
+	tried.endpoints = append(tried.endpoints[:len(tried.endpoints):len(tried.endpoints)], ep)
+}
+
+// send 选择地址并发起一次尝试，构建请求失败时返回的req为nil
+func (invoker *Invoker) send(ctx context.Context, proto *http.Request, n int, body []byte, tried *triedEndpoints) (*http.Request, *http.Response, error) {
+	addr := invoker.addr
+	upstream := invoker.upstream
+	if upstream == nil && strings.HasPrefix(addr, ServicePrefix) {
+		discovery := invoker.discovery
+		if discovery == nil {
+			discovery = DefaultDiscovery
+		}
+
+		var err error
+		upstream, err = discovery.Upstream(ctx, strings.TrimPrefix(addr, ServicePrefix))
+		if err != nil {
+			return nil, nil, &InvokeError{"ResolveService", err, true, false}
+		}
 	}
 
-	if invoker.doRequest == nil {
-		invoker.doRequest = DefaultDoRequest
+	var endpoint *Endpoint
+	if upstream != nil {
+		endpoint = upstream.pick(invoker.balanceKey, tried.list())
+		if endpoint == nil {
+			return nil, nil, &InvokeError{"PickAddr", ErrNoAvailableAddr, false, false}
+		}
+		addr = endpoint.Addr
+		tried.add(endpoint)
+		atomic.AddInt64(&endpoint.pending, 1)
+		defer atomic.AddInt64(&endpoint.pending, -1)
 	}
 
-	rsp, err := invoker.doRequest(invoker.client, req)
-	if err != nil {
+	req, rsp, err := invoker.attempt(ctx, proto, n, addr, body)
+
+	// 被主动取消的尝试不计入地址的失败
+	if req != nil && endpoint != nil && ctx.Err() == nil {
+		upstream.report(endpoint, err != nil || (rsp != nil && rsp.StatusCode >= 500))
+	}
+	return req, rsp, err
+}
+
+// attempt 以proto的副本向addr发起一次请求，构建请求失败时返回的req为nil
+func (invoker *Invoker) attempt(ctx context.Context, proto *http.Request, n int, addr string, body []byte) (*http.Request, *http.Response, error) {
+	req := proto.Clone(ctx)
+	if host := targetHost(addr); host != proto.URL.Host {
+		req.URL.Host = host
+		if req.Host == hostHeader(proto.URL.Host) {
+			req.Host = hostHeader(host)
+		}
+	}
+
+	switch {
+	case proto.GetBody != nil:
+		rc, err := proto.GetBody()
+		if err != nil {
+			return nil, nil, &InvokeError{"BuildRequest", err, false, false}
+		}
+		req.Body = rc
+	case invoker.stream != nil:
+		switch {
+		case invoker.stream.size > 0:
+			rc, err := invoker.stream.open()
+			if err != nil {
+				return nil, nil, &InvokeError{"OpenStream", err, false, false}
+			}
+			req.Body = rc
+			req.ContentLength = invoker.stream.size
+		case invoker.stream.size == 0:
+			req.Body = http.NoBody
+		default:
+			rc, err := invoker.stream.open()
+			if err != nil {
+				return nil, nil, &InvokeError{"OpenStream", err, false, false}
+			}
+			req.Body = rc
+			req.ContentLength = -1
+		}
+	}
+
+	if invoker.signer != nil {
+		if err := invoker.signer.Sign(req, body); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, nil, &InvokeError{"SignRequest", err, false, false}
+		}
+	}
+
+	req, observeDone := observeRequest(invoker.observers, n, req)
+	var recordDone func(*http.Response, error)
+	if invoker.recorder != nil {
+		req, recordDone = invoker.recorder.start(req)
+	}
+	rsp, err := invoker.roundTrip(req)
+	observeDone(rsp, err)
+	if _, ok := err.(*InvokeError); err != nil && !ok {
 		returnErr := &InvokeError{
 			Action: "DoRequest",
 			Err:    err,
 		}
+		if errors.Is(err, ErrPinMismatch) {
+			returnErr.Action = "VerifyPin"
+		}
 		te, ok := err.(TemporaryError)
 		if ok {
 			returnErr.IsTemporary = te.Temporary()
@@ -425,27 +750,68 @@ func (invoker *Invoker) Request() (*http.Response, error) {
 			returnErr.IsTimeout = timeoutErr.Timeout()
 		}
 		err = returnErr
+	} else if err == nil {
+		err = decompressResponse(rsp)
 	}
 
-	if rsp != nil && invoker.parseStatus != nil {
-		err = invoker.parseStatus(rsp)
-		if invoker.closeBodyAfterRequest && rsp.Body != nil {
-			defer rsp.Body.Close()
-		}
+	if recordDone != nil {
+		recordDone(rsp, err)
 	}
+	return req, rsp, err
+}
 
-	for _, afterRequest := range invoker.afterRequests {
-		err = afterRequest(invoker.userData, rsp, err)
+// idempotentMethod 默认可以重试与对冲的请求方法
+func idempotentMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
 	}
+	return false
+}
 
-	if invoker.logger != nil {
-		invoker.logger(time.Since(now), req, rsp, err)
+func defaultRetryOn(rsp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrRateLimited)
 	}
 
-	return rsp, err
+	switch rsp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
 }
 
-func (invoker *Invoker) AfterRequest(fnList ...func(interface{}, *http.Response, error) error) *Invoker {
-	invoker.afterRequests = fnList
-	return invoker
+// roundTrip 依次经过缓存,请求合并与限流后发送请求
+// 未能发送时（限流，缓存命中或合并到其他请求）由此关闭请求体
+func (invoker *Invoker) roundTrip(req *http.Request) (*http.Response, error) {
+	var sent int32
+	do := func(req *http.Request) (*http.Response, error) {
+		atomic.CompareAndSwapInt32(&sent, 0, 1)
+		return invoker.doRequest(invoker.client, req)
+	}
+	if limiter := invoker.rateLimiter; limiter != nil {
+		next, weight := do, invoker.requestWeight
+		do = func(req *http.Request) (*http.Response, error) {
+			return limiter.do(req, weight, next)
+		}
+	}
+	if coalescer := invoker.coalescer; coalescer != nil {
+		next := do
+		do = func(req *http.Request) (*http.Response, error) {
+			return coalescer.Do(req, next)
+		}
+	}
+
+	var rsp *http.Response
+	var err error
+	if invoker.cache != nil {
+		rsp, err = invoker.cache.Do(req, do)
+	} else {
+		rsp, err = do(req)
+	}
+
+	if req.Body != nil && atomic.CompareAndSwapInt32(&sent, 0, 2) {
+		req.Body.Close()
+	}
+	return rsp, err
 }
@@ -1,4 +1,4 @@
-package invoke
+package invoke_test
 
 import (
 	"context"
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/hello-pionex/mystic-go/code"
+	"github.com/hello-pionex/mystic-go/invoke"
+	"github.com/hello-pionex/mystic-go/invoke/invoketest"
 )
 
 func TestInvoke(t *testing.T) {
@@ -21,7 +23,7 @@ func TestInvoke(t *testing.T) {
 
 		b, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			return &InvokeError{Action: "ReadResponseBody", Err: fmt.Errorf("read response body:%v", err)}
+			return &invoke.InvokeError{Action: "ReadResponseBody", Err: fmt.Errorf("read response body:%v", err)}
 		}
 
 		type JsonWrapper struct {
@@ -33,14 +35,14 @@ func TestInvoke(t *testing.T) {
 
 		var wrapper JsonWrapper
 		if err := json.Unmarshal(b, &wrapper); err != nil {
-			return &InvokeError{Action: "ParsePayload", Err: fmt.Errorf("parse json wrapper:%v", err)}
+			return &invoke.InvokeError{Action: "ParsePayload", Err: fmt.Errorf("parse json wrapper:%v", err)}
 		}
 		if !wrapper.Result {
 			return code.NewMcode(wrapper.Mcode, wrapper.Message)
 		}
 
 		if err := json.Unmarshal(b, userData); err != nil {
-			return &InvokeError{Action: "ParsePayload", Err: fmt.Errorf("parse json data:%v", err)}
+			return &invoke.InvokeError{Action: "ParsePayload", Err: fmt.Errorf("parse json data:%v", err)}
 		}
 
 		return nil
@@ -76,7 +78,7 @@ func TestInvoke(t *testing.T) {
 	}
 
 	// template
-	ftxApi := New().
+	ftxApi := invoke.New().
 		Timeout(time.Second*10).      // timeout
 		EncodingGzip().               // easy way to set header Content-Encoding to be `gzip`
 		OnlyStatus200().              // treat all status as error expect 200
@@ -91,6 +93,10 @@ func TestInvoke(t *testing.T) {
 		}).   // callback before http.Do execute, so user have a chance to customize request
 		TLS() // change scheme from http to https
 
+	// replay recorded responses of ftx.com, run with INVOKE_RECORD=1 to record again
+	cassette := invoketest.Use(t, "testdata/ftx_trades.json").IgnoreQuery("timestamp")
+	ftxApi.DoRequest(cassette.DoRequest)
+
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(time.Second*5))
 	defer cancelFn()
 
@@ -120,9 +126,11 @@ func TestInvoke(t *testing.T) {
 		}).
 		Request() // execute the request
 	if err != nil {
-		_ = err
+		t.Fatal(err)
 	}
 
 	fmt.Println(ret)
-	_ = rsp
+	if rsp.StatusCode != 200 || !ret.Success || len(ret.Result) != 2 {
+		t.Fatalf("unexpected response %+v", ret)
+	}
 }
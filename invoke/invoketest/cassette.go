@@ -0,0 +1,554 @@
+// Package invoketest 为基于invoke的测试提供录制/回放(cassette)与桩服务
+package invoketest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Mode int
+
+const (
+	// ModeReplay 只从文件回放，未匹配的请求返回错误
+	ModeReplay Mode = iota
+	// ModeRecord 请求真实服务并录制，Save 时写入文件
+	ModeRecord
+)
+
+// RecordEnv 设置该环境变量为1时 Use 使用录制模式
+const RecordEnv = "INVOKE_RECORD"
+
+const Redacted = "REDACTED"
+
+// Match 请求匹配的维度
+type Match int
+
+const (
+	MatchMethod Match = 1 << iota
+	MatchPath
+	MatchQuery
+	MatchBody
+
+	MatchDefault = MatchMethod | MatchPath | MatchQuery
+)
+
+// Interaction 一次录制的请求与响应
+type Interaction struct {
+	Request  RecordedRequest  `json:"request" yaml:"request"`
+	Response RecordedResponse `json:"response" yaml:"response"`
+}
+
+type RecordedRequest struct {
+	Method     string              `json:"method" yaml:"method"`
+	URL        string              `json:"url" yaml:"url"`
+	Header     map[string][]string `json:"header,omitempty" yaml:"header,omitempty"`
+	Body       string              `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyBase64 bool                `json:"bodyBase64,omitempty" yaml:"bodyBase64,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status     int                 `json:"status" yaml:"status"`
+	Header     map[string][]string `json:"header,omitempty" yaml:"header,omitempty"`
+	Body       string              `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyBase64 bool                `json:"bodyBase64,omitempty" yaml:"bodyBase64,omitempty"`
+}
+
+type cassetteFile struct {
+	Interactions []*Interaction `json:"interactions" yaml:"interactions"`
+}
+
+// Cassette 录制/回放请求，通过 Invoker.DoRequest(cassette.DoRequest) 或 Invoker.Client(cassette.Client()) 接入
+//
+//	cassette := invoketest.Use(t, "testdata/partner.yaml").RedactHeader("X-Api-Key")
+//	rsp, err := invoker.DoRequest(cassette.DoRequest).Request()
+type Cassette struct {
+	path string
+	mode Mode
+
+	// Transport 录制模式下通过 Client 接入时使用的真实传输层，为空使用http.DefaultTransport
+	Transport http.RoundTripper
+
+	mutex        sync.Mutex
+	match        Match
+	ignoreQuery  map[string]bool
+	redactHeader map[string]bool
+	redactQuery  map[string]bool
+	redactFields map[string]bool
+	interactions []*Interaction
+	used         []bool
+	unmatched    []string
+}
+
+// New 创建cassette，回放模式下立即加载文件，文件格式按扩展名为yaml或json
+func New(path string, mode Mode) (*Cassette, error) {
+	cassette := &Cassette{
+		path:         path,
+		mode:         mode,
+		match:        MatchDefault,
+		ignoreQuery:  make(map[string]bool),
+		redactHeader: make(map[string]bool),
+		redactQuery:  make(map[string]bool),
+		redactFields: make(map[string]bool),
+	}
+
+	if mode == ModeReplay {
+		if err := cassette.load(); err != nil {
+			return nil, err
+		}
+	}
+	return cassette, nil
+}
+
+// Use 用于测试，设置环境变量INVOKE_RECORD=1时录制，否则回放
+// 测试结束时录制模式保存文件，回放模式下存在未匹配的请求则测试失败
+func Use(t testing.TB, path string) *Cassette {
+	t.Helper()
+
+	mode := ModeReplay
+	if os.Getenv(RecordEnv) == "1" {
+		mode = ModeRecord
+	}
+
+	cassette, err := New(path, mode)
+	if err != nil {
+		t.Fatalf("invoketest: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if mode == ModeRecord {
+			if err := cassette.Save(); err != nil {
+				t.Errorf("invoketest: %v", err)
+			}
+			return
+		}
+		for _, request := range cassette.Unmatched() {
+			t.Errorf("invoketest: no interaction matched %s", request)
+		}
+	})
+	return cassette
+}
+
+// Match 设置匹配维度
+func (cassette *Cassette) Match(match Match) *Cassette {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	cassette.match = match
+	return cassette
+}
+
+// IgnoreQuery 匹配查询串时忽略的参数，例如timestamp
+func (cassette *Cassette) IgnoreQuery(keys ...string) *Cassette {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	for _, key := range keys {
+		cassette.ignoreQuery[key] = true
+	}
+	return cassette
+}
+
+// RedactHeader 录制时隐藏的头部，请求与响应都生效
+func (cassette *Cassette) RedactHeader(keys ...string) *Cassette {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	for _, key := range keys {
+		cassette.redactHeader[http.CanonicalHeaderKey(key)] = true
+	}
+	return cassette
+}
+
+// RedactQuery 录制时隐藏的查询参数，例如signature
+func (cassette *Cassette) RedactQuery(keys ...string) *Cassette {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	for _, key := range keys {
+		cassette.redactQuery[key] = true
+	}
+	return cassette
+}
+
+// RedactBodyField 录制时隐藏json请求体与响应体中任意层级的同名字段
+func (cassette *Cassette) RedactBodyField(fields ...string) *Cassette {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	for _, field := range fields {
+		cassette.redactFields[field] = true
+	}
+	return cassette
+}
+
+// DoRequest 用于 Invoker.DoRequest
+func (cassette *Cassette) DoRequest(c *http.Client, req *http.Request) (*http.Response, error) {
+	if cassette.mode == ModeReplay {
+		return cassette.replay(req)
+	}
+	return cassette.record(req, c.Do)
+}
+
+// Client 返回使用该cassette作为传输层的http.Client
+func (cassette *Cassette) Client() *http.Client {
+	return &http.Client{Transport: cassette}
+}
+
+func (cassette *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if cassette.mode == ModeReplay {
+		return cassette.replay(req)
+	}
+
+	transport := cassette.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return cassette.record(req, transport.RoundTrip)
+}
+
+// Unmatched 回放模式下未匹配的请求
+func (cassette *Cassette) Unmatched() []string {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	return append([]string(nil), cassette.unmatched...)
+}
+
+// Interactions 已加载或已录制的交互
+func (cassette *Cassette) Interactions() []*Interaction {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	return append([]*Interaction(nil), cassette.interactions...)
+}
+
+func (cassette *Cassette) record(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rspBody, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(rspBody))
+
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    cassette.redactURL(req.URL),
+			Header: cassette.redactHeaders(req.Header),
+		},
+		Response: RecordedResponse{
+			Status: rsp.StatusCode,
+			Header: cassette.redactHeaders(rsp.Header),
+		},
+	}
+	interaction.Request.Body, interaction.Request.BodyBase64 = encodeBody(cassette.redactBody(reqBody))
+	interaction.Response.Body, interaction.Response.BodyBase64 = encodeBody(cassette.redactBody(rspBody))
+
+	cassette.interactions = append(cassette.interactions, interaction)
+	cassette.used = append(cassette.used, true)
+	return rsp, nil
+}
+
+func (cassette *Cassette) replay(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	live := RecordedRequest{
+		Method: req.Method,
+		URL:    cassette.redactURL(req.URL),
+	}
+	live.Body, live.BodyBase64 = encodeBody(cassette.redactBody(body))
+
+	// 优先使用未回放过的交互
+	found := -1
+	for i, interaction := range cassette.interactions {
+		if cassette.matches(&interaction.Request, &live) {
+			if !cassette.used[i] {
+				found = i
+				break
+			}
+			if found < 0 {
+				found = i
+			}
+		}
+	}
+
+	if found < 0 {
+		desc := req.Method + " " + req.URL.String()
+		cassette.unmatched = append(cassette.unmatched, desc)
+		return nil, fmt.Errorf("invoketest: no interaction in %s matched %s", cassette.path, desc)
+	}
+	cassette.used[found] = true
+
+	recorded := cassette.interactions[found].Response
+	rspBody, err := decodeBody(recorded.Body, recorded.BodyBase64)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	for key, values := range recorded.Header {
+		header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(rspBody)),
+		ContentLength: int64(len(rspBody)),
+		Request:       req,
+	}, nil
+}
+
+func (cassette *Cassette) matches(recorded, live *RecordedRequest) bool {
+	if cassette.match&MatchMethod != 0 && !strings.EqualFold(recorded.Method, live.Method) {
+		return false
+	}
+
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	liveURL, _ := url.Parse(live.URL)
+
+	if cassette.match&MatchPath != 0 && recordedURL.Path != liveURL.Path {
+		return false
+	}
+
+	if cassette.match&MatchQuery != 0 && !cassette.equalQuery(recordedURL.Query(), liveURL.Query()) {
+		return false
+	}
+
+	if cassette.match&MatchBody != 0 && !equalBody(recorded, live) {
+		return false
+	}
+
+	return true
+}
+
+// equalQuery 比较查询参数，忽略 IgnoreQuery 中的参数，录制时被隐藏的值匹配任意值
+func (cassette *Cassette) equalQuery(recorded, live url.Values) bool {
+	for key := range cassette.ignoreQuery {
+		delete(recorded, key)
+		delete(live, key)
+	}
+
+	if len(recorded) != len(live) {
+		return false
+	}
+
+	for key, values := range recorded {
+		liveValues, exist := live[key]
+		if !exist || len(liveValues) != len(values) {
+			return false
+		}
+		for i, value := range values {
+			if value != Redacted && value != liveValues[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func equalBody(recorded, live *RecordedRequest) bool {
+	if recorded.Body == live.Body && recorded.BodyBase64 == live.BodyBase64 {
+		return true
+	}
+	if recorded.BodyBase64 || live.BodyBase64 {
+		return false
+	}
+
+	// json按语义比较，忽略字段顺序
+	var a, b interface{}
+	if json.Unmarshal([]byte(recorded.Body), &a) != nil || json.Unmarshal([]byte(live.Body), &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (cassette *Cassette) redactURL(u *url.URL) string {
+	redacted := *u
+	if len(cassette.redactQuery) > 0 {
+		values := u.Query()
+		for key := range values {
+			if cassette.redactQuery[key] {
+				values[key] = []string{Redacted}
+			}
+		}
+		redacted.RawQuery = values.Encode()
+	}
+	return redacted.String()
+}
+
+func (cassette *Cassette) redactHeaders(header http.Header) map[string][]string {
+	redacted := make(map[string][]string, len(header))
+	for key, values := range header {
+		if cassette.redactHeader[http.CanonicalHeaderKey(key)] {
+			redacted[key] = []string{Redacted}
+			continue
+		}
+		redacted[key] = append([]string(nil), values...)
+	}
+	return redacted
+}
+
+func (cassette *Cassette) redactBody(body []byte) []byte {
+	if len(cassette.redactFields) == 0 || len(body) == 0 {
+		return body
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+
+	b, err := json.Marshal(redactValue(v, cassette.redactFields))
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+func redactValue(v interface{}, fields map[string]bool) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if fields[key] {
+				value[key] = Redacted
+				continue
+			}
+			value[key] = redactValue(item, fields)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item, fields)
+		}
+	}
+	return v
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
+
+func isYaml(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func (cassette *Cassette) load() error {
+	b, err := ioutil.ReadFile(cassette.path)
+	if err != nil {
+		return fmt.Errorf("load cassette:%v", err)
+	}
+
+	var file cassetteFile
+	if isYaml(cassette.path) {
+		err = yaml.Unmarshal(b, &file)
+	} else {
+		err = json.Unmarshal(b, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("parse cassette %s:%v", cassette.path, err)
+	}
+
+	cassette.interactions = file.Interactions
+	cassette.used = make([]bool, len(file.Interactions))
+	return nil
+}
+
+// Save 录制模式下将交互写入文件
+func (cassette *Cassette) Save() error {
+	cassette.mutex.Lock()
+	defer cassette.mutex.Unlock()
+
+	file := cassetteFile{Interactions: cassette.interactions}
+
+	var (
+		b   []byte
+		err error
+	)
+	if isYaml(cassette.path) {
+		b, err = yaml.Marshal(&file)
+	} else {
+		b, err = json.MarshalIndent(&file, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("encode cassette:%v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cassette.path), 0755); err != nil {
+		return fmt.Errorf("save cassette:%v", err)
+	}
+	return ioutil.WriteFile(cassette.path, b, 0644)
+}
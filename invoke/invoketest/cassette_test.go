@@ -0,0 +1,75 @@
+package invoketest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"secret-token","symbol":"BTC_USDT"}`))
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	for _, name := range []string{"cassette.yaml", "cassette.json"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		recorder, err := New(path, ModeRecord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder.RedactHeader("X-Api-Key").RedactQuery("signature").RedactBodyField("token")
+
+		rsp, err := invoke.Addr(addr).Get("/v1/symbol").
+			Query("symbol", "BTC_USDT").
+			Query("signature", "abc").
+			Header("X-Api-Key", "key").
+			DoRequest(recorder.DoRequest).
+			Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+
+		if err := recorder.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := ioutil.ReadFile(path)
+		if strings.Contains(string(b), "secret-token") || strings.Contains(string(b), "abc") || strings.Contains(string(b), `"key"`) {
+			t.Fatalf("%s not redacted:\n%s", name, b)
+		}
+
+		player, err := New(path, ModeReplay)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err = invoke.Addr("offline.invalid").Get("/v1/symbol").
+			Query("symbol", "BTC_USDT").
+			Query("signature", "other").
+			Client(player.Client()).
+			Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if !strings.Contains(string(body), "BTC_USDT") {
+			t.Fatalf("unexpected body %s", body)
+		}
+
+		_, err = invoke.Addr("offline.invalid").Get("/v1/unknown").Client(player.Client()).Request()
+		if err == nil || len(player.Unmatched()) != 1 {
+			t.Fatal("unmatched request should fail")
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package invoketest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hello-pionex/mystic-go/invoke"
+	"github.com/hello-pionex/mystic-go/invokeutils"
+)
+
+// Fault 注入的故障
+type Fault int
+
+const (
+	FaultNone Fault = iota
+	// FaultTimeout 不响应，直到客户端放弃或服务关闭
+	FaultTimeout
+	// FaultReset 读取请求后直接重置连接
+	FaultReset
+	// FaultMalformedJSON 返回200以及无法解析的json
+	FaultMalformedJSON
+	// FaultServerError 返回500
+	FaultServerError
+)
+
+// Server 进程内的桩服务，按声明的期望响应请求，测试结束时校验所有期望都被满足
+//
+//	server := invoketest.NewServer(t)
+//	server.Expect("POST", "/v1/order").BodyJSON(order).Delay(50 * time.Millisecond).Times(2).RespondOK(result)
+//	invoker := server.Invoker().Post("/v1/order").Json(order)
+type Server struct {
+	*httptest.Server
+
+	t            testing.TB
+	mutex        sync.Mutex
+	expectations []*Expectation
+	unexpected   []string
+}
+
+// NewServer 启动桩服务，测试结束时关闭并调用 Verify
+func NewServer(t testing.TB) *Server {
+	server := &Server{t: t}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+
+	t.Cleanup(func() {
+		server.Close()
+		server.Verify()
+	})
+	return server
+}
+
+// Addr 用于 invoke.Addr 的地址
+func (server *Server) Addr() string {
+	return server.Listener.Addr().String()
+}
+
+// Invoker 指向该桩服务的invoker
+func (server *Server) Invoker() *invoke.Invoker {
+	return invoke.Addr(server.Addr())
+}
+
+// Expect 声明一个期望，默认只匹配一次
+func (server *Server) Expect(method, path string) *Expectation {
+	expectation := &Expectation{
+		method: strings.ToUpper(method),
+		path:   path,
+		times:  1,
+		status: http.StatusOK,
+		header: http.Header{},
+	}
+
+	server.mutex.Lock()
+	server.expectations = append(server.expectations, expectation)
+	server.mutex.Unlock()
+	return expectation
+}
+
+// Verify 校验所有期望的调用次数，以及没有未声明的请求
+func (server *Server) Verify() {
+	server.t.Helper()
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, request := range server.unexpected {
+		server.t.Errorf("invoketest: unexpected request %s", request)
+	}
+
+	for _, expectation := range server.expectations {
+		expectation.mutex.Lock()
+		if expectation.times >= 0 && expectation.calls < expectation.times {
+			server.t.Errorf("invoketest: %s %s expected %d calls, got %d",
+				expectation.method, expectation.path, expectation.times, expectation.calls)
+		}
+		expectation.mutex.Unlock()
+	}
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	server.mutex.Lock()
+	var matched *Expectation
+	for _, expectation := range server.expectations {
+		if expectation.take(r, body) {
+			matched = expectation
+			break
+		}
+	}
+	if matched == nil {
+		server.unexpected = append(server.unexpected, r.Method+" "+r.URL.String())
+	}
+	server.mutex.Unlock()
+
+	if matched == nil {
+		http.Error(w, "invoketest: no expectation matched", http.StatusNotImplemented)
+		return
+	}
+
+	matched.respond(w, r)
+}
+
+// Expectation 一个请求期望以及对应的响应
+type Expectation struct {
+	mutex sync.Mutex
+
+	method  string
+	path    string
+	queries map[string]string
+	headers map[string]string
+	body    func([]byte) bool
+
+	times int // 小于0表示不限次数
+	calls int
+
+	delay  time.Duration
+	fault  Fault
+	status int
+	header http.Header
+	data   []byte
+}
+
+// Query 要求请求包含该查询参数
+func (expectation *Expectation) Query(key, value string) *Expectation {
+	if expectation.queries == nil {
+		expectation.queries = make(map[string]string)
+	}
+	expectation.queries[key] = value
+	return expectation
+}
+
+// Header 要求请求包含该头部
+func (expectation *Expectation) Header(key, value string) *Expectation {
+	if expectation.headers == nil {
+		expectation.headers = make(map[string]string)
+	}
+	expectation.headers[key] = value
+	return expectation
+}
+
+// Body 使用自定义函数匹配请求体
+func (expectation *Expectation) Body(match func(body []byte) bool) *Expectation {
+	expectation.body = match
+	return expectation
+}
+
+// BodyJSON 要求请求体与v的json语义相同，忽略字段顺序
+func (expectation *Expectation) BodyJSON(v interface{}) *Expectation {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	var expect interface{}
+	json.Unmarshal(b, &expect)
+
+	return expectation.Body(func(body []byte) bool {
+		var actual interface{}
+		if err := json.Unmarshal(body, &actual); err != nil {
+			return false
+		}
+		return reflect.DeepEqual(expect, actual)
+	})
+}
+
+// BodyContains 要求请求体包含s
+func (expectation *Expectation) BodyContains(s string) *Expectation {
+	return expectation.Body(func(body []byte) bool {
+		return bytes.Contains(body, []byte(s))
+	})
+}
+
+// Times 期望恰好匹配n次，超出的请求视为未声明的请求
+func (expectation *Expectation) Times(n int) *Expectation {
+	expectation.times = n
+	return expectation
+}
+
+// AnyTimes 不限制匹配次数，包括0次
+func (expectation *Expectation) AnyTimes() *Expectation {
+	expectation.times = -1
+	return expectation
+}
+
+// Delay 响应前等待d
+func (expectation *Expectation) Delay(d time.Duration) *Expectation {
+	expectation.delay = d
+	return expectation
+}
+
+// Fault 注入故障
+func (expectation *Expectation) Fault(fault Fault) *Expectation {
+	expectation.fault = fault
+	return expectation
+}
+
+// ResponseHeader 设置响应头部
+func (expectation *Expectation) ResponseHeader(key, value string) *Expectation {
+	expectation.header.Set(key, value)
+	return expectation
+}
+
+// Respond 原样返回状态与响应体
+func (expectation *Expectation) Respond(status int, body []byte) *Expectation {
+	expectation.status = status
+	expectation.data = body
+	return expectation
+}
+
+// RespondJSON 返回v的json
+func (expectation *Expectation) RespondJSON(status int, v interface{}) *Expectation {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	expectation.header.Set("Content-Type", "application/json")
+	return expectation.Respond(status, b)
+}
+
+// RespondOK 返回成功的 invokeutils.Response 封装，data为其中的数据
+func (expectation *Expectation) RespondOK(data interface{}) *Expectation {
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return expectation.RespondJSON(http.StatusOK, &invokeutils.Response{Result: true, Data: b})
+}
+
+// RespondError 返回失败的 invokeutils.Response 封装
+func (expectation *Expectation) RespondError(mcode, message string) *Expectation {
+	return expectation.RespondJSON(http.StatusOK, &invokeutils.Response{Code: mcode, Message: message})
+}
+
+// take 请求匹配且次数未用完时计数并返回true
+func (expectation *Expectation) take(r *http.Request, body []byte) bool {
+	expectation.mutex.Lock()
+	defer expectation.mutex.Unlock()
+
+	if expectation.times >= 0 && expectation.calls >= expectation.times {
+		return false
+	}
+	if expectation.method != "" && expectation.method != r.Method {
+		return false
+	}
+	if expectation.path != "" && expectation.path != r.URL.Path {
+		return false
+	}
+
+	query := r.URL.Query()
+	for key, value := range expectation.queries {
+		if query.Get(key) != value {
+			return false
+		}
+	}
+	for key, value := range expectation.headers {
+		if r.Header.Get(key) != value {
+			return false
+		}
+	}
+	if expectation.body != nil && !expectation.body(body) {
+		return false
+	}
+
+	expectation.calls++
+	return true
+}
+
+func (expectation *Expectation) respond(w http.ResponseWriter, r *http.Request) {
+	if expectation.delay > 0 {
+		timer := time.NewTimer(expectation.delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	switch expectation.fault {
+	case FaultTimeout:
+		<-r.Context().Done()
+		return
+	case FaultReset:
+		resetConnection(w)
+		return
+	case FaultMalformedJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result":true,"data":{`))
+		return
+	case FaultServerError:
+		http.Error(w, "invoketest: injected server error", http.StatusInternalServerError)
+		return
+	}
+
+	for key, values := range expectation.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(expectation.status)
+	w.Write(expectation.data)
+}
+
+// resetConnection 接管连接并以RST关闭
+func resetConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic(fmt.Sprintf("invoketest: %T can not hijack", w))
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
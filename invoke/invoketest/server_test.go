@@ -0,0 +1,67 @@
+package invoketest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hello-pionex/mystic-go/invokeutils"
+)
+
+func TestServerExpectations(t *testing.T) {
+	type order struct {
+		Symbol string `json:"symbol"`
+		Size   string `json:"size"`
+	}
+	type result struct {
+		OrderID string `json:"orderId"`
+	}
+
+	server := NewServer(t)
+	server.Expect("POST", "/v1/order").
+		BodyJSON(order{Symbol: "BTC_USDT", Size: "1"}).
+		Delay(50 * time.Millisecond).
+		Times(2).
+		RespondOK(result{OrderID: "1"})
+	server.Expect("POST", "/v1/order").RespondError("INSUFFICIENT_BALANCE", "no money")
+
+	ctx := context.Background()
+	invoker := server.Invoker().Post("/v1/order").Json(order{Symbol: "BTC_USDT", Size: "1"})
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		ret, err := invokeutils.Call[result](ctx, invoker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.OrderID != "1" || time.Since(start) < 50*time.Millisecond {
+			t.Fatalf("unexpected result %+v", ret)
+		}
+	}
+
+	_, err := invokeutils.Call[result](ctx, invoker)
+	if err == nil || err.Mcode() != "INSUFFICIENT_BALANCE" {
+		t.Fatalf("expect upstream mcode, got %v", err)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	server := NewServer(t)
+	server.Expect("GET", "/timeout").Fault(FaultTimeout)
+	server.Expect("GET", "/reset").Fault(FaultReset)
+	server.Expect("GET", "/malformed").Fault(FaultMalformedJSON)
+	server.Expect("GET", "/error").Fault(FaultServerError)
+
+	ctx := context.Background()
+	for path, mcode := range map[string]string{
+		"/timeout":   invokeutils.MCODE_INVOKE_TIMEOUT,
+		"/reset":     invokeutils.MCODE_INVOKE_CONN_RESET,
+		"/malformed": invokeutils.MCODE_INVOKE_MALFORMED,
+		"/error":     invokeutils.MCODE_INVOKE_FAILED,
+	} {
+		_, err := invokeutils.Call[map[string]interface{}](ctx, server.Invoker().Get(path).Timeout(100*time.Millisecond))
+		if err == nil || err.Mcode() != mcode {
+			t.Fatalf("%s expect %s got %v", path, mcode, err)
+		}
+	}
+}
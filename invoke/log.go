@@ -0,0 +1,336 @@
+package invoke
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogRedacted 日志中替换敏感值的占位
+const LogRedacted = "REDACTED"
+
+var (
+	// DefaultRedactHeaders 默认脱敏的请求头部
+	DefaultRedactHeaders = []string{
+		"Authorization", "Proxy-Authorization", "Cookie",
+		"X-Api-Key", "Api-Key", "X-Mbx-Apikey", "X-Signature", "Signature",
+		"X-Amz-Security-Token", "Ok-Access-Key", "Ok-Access-Sign", "Ok-Access-Passphrase",
+	}
+	// DefaultRedactQueries 默认脱敏的查询参数
+	DefaultRedactQueries = []string{
+		"signature", "sign", "apiKey", "api_key", "key", "token", "access_token",
+		"X-Amz-Signature", "X-Amz-Credential", "X-Amz-Security-Token",
+	}
+	// DefaultRedactFields 默认脱敏的json字段，同时用于表单字段
+	DefaultRedactFields = []string{
+		"password", "secret", "token", "apiKey", "apiSecret", "secretKey",
+		"signature", "privateKey", "passphrase",
+	}
+)
+
+// DefaultLogBodySize 日志中请求体与响应体的默认最大长度
+const DefaultLogBodySize = 1024
+
+// LogPolicy 默认日志的脱敏,截断与级别，名称匹配不区分大小写
+type LogPolicy struct {
+	headers       []string
+	queries       []string
+	fields        []string
+	maxBodySize   int
+	successLevel  logrus.Level
+	failureLevel  logrus.Level
+	responseBody  bool
+	curlOnFailure bool
+}
+
+var defaultLogPolicy = NewLogPolicy()
+
+// NewLogPolicy 使用默认脱敏列表，成功Info失败Error，请求体截断到 DefaultLogBodySize
+func NewLogPolicy() *LogPolicy {
+	return &LogPolicy{
+		headers:      DefaultRedactHeaders,
+		queries:      DefaultRedactQueries,
+		fields:       DefaultRedactFields,
+		maxBodySize:  DefaultLogBodySize,
+		successLevel: logrus.InfoLevel,
+		failureLevel: logrus.ErrorLevel,
+	}
+}
+
+// RedactHeaders 追加脱敏的头部
+func (policy *LogPolicy) RedactHeaders(headers ...string) *LogPolicy {
+	policy.headers = append(policy.headers[:len(policy.headers):len(policy.headers)], headers...)
+	return policy
+}
+
+// RedactQueries 追加脱敏的查询参数
+func (policy *LogPolicy) RedactQueries(queries ...string) *LogPolicy {
+	policy.queries = append(policy.queries[:len(policy.queries):len(policy.queries)], queries...)
+	return policy
+}
+
+// RedactFields 追加脱敏的json或表单字段，json中任意层级的同名字段都会脱敏
+func (policy *LogPolicy) RedactFields(fields ...string) *LogPolicy {
+	policy.fields = append(policy.fields[:len(policy.fields):len(policy.fields)], fields...)
+	return policy
+}
+
+// MaxBodySize 请求体与响应体在日志中的最大长度，超出部分以截断标记代替，小于0时不记录
+func (policy *LogPolicy) MaxBodySize(size int) *LogPolicy {
+	policy.maxBodySize = size
+	return policy
+}
+
+// Levels 成功与失败(出错或状态码非200)时的日志级别
+func (policy *LogPolicy) Levels(success, failure logrus.Level) *LogPolicy {
+	policy.successLevel = success
+	policy.failureLevel = failure
+	return policy
+}
+
+// ResponseBodyOnFailure 失败时记录截断后的响应体，调用方仍然可以读取完整的响应体
+func (policy *LogPolicy) ResponseBodyOnFailure() *LogPolicy {
+	policy.responseBody = true
+	return policy
+}
+
+// LogPolicy 设置默认日志的策略
+func (invoker *Invoker) LogPolicy(policy *LogPolicy) *Invoker {
+	invoker.logPolicy = policy
+	return invoker
+}
+
+func (invoker *Invoker) debugLogger(d time.Duration, req *http.Request, rsp *http.Response, err error) {
+	policy := invoker.logPolicy
+	if policy == nil {
+		policy = defaultLogPolicy
+	}
+
+	if req == nil {
+		logrus.WithError(err).Logln(policy.failureLevel, "InvokeFailed")
+		return
+	}
+
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	info := invokeInfoFrom(req)
+	if info != nil && info.encoding != "" {
+		entry = entry.WithFields(logrus.Fields{
+			"encoding":    info.encoding,
+			"payloadSize": info.payloadSize,
+			"encodedSize": info.encodedSize,
+		})
+	}
+	if info != nil && info.cacheStatus() != CacheNone {
+		entry = entry.WithField("cache", info.cacheStatus().String())
+	}
+
+	route := req.URL.Path
+	if info != nil && info.route != "" {
+		route = info.route
+	}
+
+	fields := logrus.Fields{
+		"cost":    d,
+		"method":  req.Method,
+		"url":     policy.redactURL(req.URL),
+		"route":   route,
+		"header":  policy.redactHeader(req.Header),
+		"payload": policy.payload(req, info),
+	}
+
+	statusCode := 0
+	if rsp != nil {
+		statusCode = rsp.StatusCode
+	}
+
+	switch {
+	case err != nil:
+		fields["error"] = err
+	case statusCode != 200:
+		fields["error"] = fmt.Sprintf("status=%d", statusCode)
+		fields["status"] = statusCode
+	default:
+		fields["status"] = statusCode
+		entry.WithFields(fields).Logln(policy.successLevel, "InvokeDone")
+		return
+	}
+
+	if policy.responseBody && rsp != nil && rsp.Body != nil && policy.maxBodySize >= 0 {
+		fields["response"] = policy.peekBody(rsp)
+	}
+	if policy.curlOnFailure {
+		fields["curl"] = policy.curl(req)
+	}
+	entry.WithFields(fields).Logln(policy.failureLevel, "InvokeFailed")
+}
+
+func matchName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *LogPolicy) redactHeader(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		if matchName(policy.headers, name) {
+			redacted[name] = []string{LogRedacted}
+			continue
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+func (policy *LogPolicy) redactURL(u *url.URL) string {
+	redacted := *u
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if matchName(policy.queries, name) {
+				query[name] = []string{LogRedacted}
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+
+	// 注册的地址显示原始的地址，例如 unix:///var/run/agent.sock/v1/status
+	if target, ok := lookupTarget(u.Host); ok {
+		return target.addr + redacted.RequestURI()
+	}
+	return redacted.String()
+}
+
+// payload 脱敏并截断的请求体，json与表单会逐字段脱敏
+func (policy *LogPolicy) payload(req *http.Request, info *invokeInfo) string {
+	if info == nil || policy.maxBodySize < 0 {
+		return ""
+	}
+	if info.streamed {
+		return "<stream>"
+	}
+
+	redacted, ok := policy.redactBody(req.Header.Get("Content-Type"), info.payload)
+	if !ok {
+		return fmt.Sprintf("<%d bytes>", len(info.payload))
+	}
+	return policy.truncate(redacted)
+}
+
+// redactBody 脱敏json或表单请求体，看起来是json或表单但无法解析(例如被截断)时返回false，
+// 此时返回的内容没有脱敏，不应记录
+func (policy *LogPolicy) redactBody(contentType string, body []byte) ([]byte, bool) {
+	if len(body) == 0 || len(policy.fields) == 0 {
+		return body, true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body, false
+		}
+		for name := range form {
+			if matchName(policy.fields, name) {
+				form[name] = []string{LogRedacted}
+			}
+		}
+		return []byte(form.Encode()), true
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		// 不是json的内容原样返回
+		trimmed := bytes.TrimSpace(body)
+		looksJson := strings.Contains(mediaType, "json") || (len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '['))
+		return body, !looksJson
+	}
+
+	b, err := json.Marshal(policy.redactJson(v))
+	if err != nil {
+		return body, false
+	}
+	return b, true
+}
+
+func (policy *LogPolicy) redactJson(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if matchName(policy.fields, key) {
+				v[key] = LogRedacted
+			} else {
+				v[key] = policy.redactJson(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = policy.redactJson(value)
+		}
+	}
+	return v
+}
+
+// truncate 超出长度时在utf8边界截断并追加截断标记
+func (policy *LogPolicy) truncate(body []byte) string {
+	if len(body) <= policy.maxBodySize {
+		return string(body)
+	}
+
+	n := policy.maxBodySize
+	for n > 0 && !utf8.RuneStart(body[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", body[:n], len(body)-n)
+}
+
+// peekBody 读取响应体的开头用于日志，并把读取的部分放回响应体
+// 先对读取的全部内容脱敏再截断，内容被截断导致无法脱敏时只记录长度
+func (policy *LogPolicy) peekBody(rsp *http.Response) string {
+	head, err := io.ReadAll(io.LimitReader(rsp.Body, int64(policy.maxBodySize)+1))
+	rsp.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(head), rsp.Body), Closer: rsp.Body}
+	if err != nil && len(head) == 0 {
+		return fmt.Sprintf("<%v>", err)
+	}
+
+	redacted, ok := policy.redactBody(rsp.Header.Get("Content-Type"), head)
+	truncated := len(head) > policy.maxBodySize
+	switch {
+	case ok && !truncated:
+		return string(redacted)
+	case ok:
+		// 只读取了开头，无法得知完整长度
+		n := policy.maxBodySize
+		if n > len(redacted) {
+			n = len(redacted)
+		}
+		for n > 0 && n < len(redacted) && !utf8.RuneStart(redacted[n]) {
+			n--
+		}
+		return string(redacted[:n]) + "...(truncated)"
+	case rsp.ContentLength >= 0:
+		return fmt.Sprintf("<%d bytes>", rsp.ContentLength)
+	case truncated:
+		return fmt.Sprintf("<more than %d bytes>", policy.maxBodySize)
+	}
+	return fmt.Sprintf("<%d bytes>", len(head))
+}
+
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
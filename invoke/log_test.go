@@ -0,0 +1,116 @@
+package invoke
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"BAD","token":"leak","detail":"` + strings.Repeat("x", 64) + `"}`))
+	}))
+	defer server.Close()
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	policy := NewLogPolicy().MaxBodySize(48).RedactFields("card").ResponseBodyOnFailure().Levels(logrus.DebugLevel, logrus.WarnLevel)
+	rsp, err := Addr(strings.TrimPrefix(server.URL, "http://")).
+		Post("/order").
+		Query("symbol", "BTC").
+		Query("signature", "abcdef").
+		Header("X-Mbx-Apikey", "my-key").
+		Json(map[string]interface{}{"user": map[string]string{"password": "p", "card": "c"}, "memo": strings.Repeat("m", 64)}).
+		LogPolicy(policy).
+		Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel || entry.Message != "InvokeFailed" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	url := entry.Data["url"].(string)
+	if strings.Contains(url, "abcdef") || !strings.Contains(url, "symbol=BTC") {
+		t.Fatalf("query not redacted: %s", url)
+	}
+	if header := entry.Data["header"].(http.Header); header.Get("X-Mbx-Apikey") != LogRedacted {
+		t.Fatalf("header not redacted: %v", header)
+	}
+
+	payload := entry.Data["payload"].(string)
+	if strings.Contains(payload, `"p"`) || strings.Contains(payload, `"c"`) || !strings.Contains(payload, "truncated") {
+		t.Fatalf("payload not redacted or truncated: %s", payload)
+	}
+
+	// 截断后的json无法脱敏，只记录长度
+	if response := entry.Data["response"].(string); strings.Contains(response, "leak") || response != fmt.Sprintf("<%d bytes>", rsp.ContentLength) {
+		t.Fatalf("response over the limit should not be logged: %s", response)
+	}
+
+	// 记录日志后调用方仍然可以读取完整的响应体
+	b, _ := ioutil.ReadAll(rsp.Body)
+	if !strings.HasSuffix(string(b), `"}`) || !strings.Contains(string(b), "leak") {
+		t.Fatalf("response body consumed by logger: %s", b)
+	}
+}
+
+func TestLogPolicyResponseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/html" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		switch r.URL.Path {
+		case "/json":
+			w.Write([]byte(`{"code":"BAD","token":"leak"}`))
+		case "/chunked":
+			w.Write([]byte(`{"token":"leak","detail":"`))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(strings.Repeat("x", 64) + `"}`))
+		default:
+			w.Write([]byte("<html>" + strings.Repeat("x", 64) + "</html>"))
+		}
+	}))
+	defer server.Close()
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	for _, c := range []struct {
+		path   string
+		expect func(string) bool
+	}{
+		// 未超出长度时完整脱敏
+		{"/json", func(s string) bool { return strings.Contains(s, `"token":"REDACTED"`) }},
+		{"/chunked", func(s string) bool { return s == "<more than 16 bytes>" }},
+		{"/html", func(s string) bool { return s == "<html>xxxxxxxxxx...(truncated)" }},
+	} {
+		maxBodySize := 16
+		if c.path == "/json" {
+			maxBodySize = 64
+		}
+		policy := NewLogPolicy().MaxBodySize(maxBodySize).ResponseBodyOnFailure()
+		if _, err := Addr(addr).Get(c.path).LogPolicy(policy).AutoCloseResponseBody().Request(); err != nil {
+			t.Fatal(err)
+		}
+
+		response, _ := hook.LastEntry().Data["response"].(string)
+		if strings.Contains(response, "leak") || !c.expect(response) {
+			t.Fatalf("%s: unexpected response %q", c.path, response)
+		}
+	}
+}
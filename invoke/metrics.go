@@ -0,0 +1,237 @@
+package invoke
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics 内置的 Observer，按host与路由(路径模板)统计各阶段耗时直方图与请求，错误计数，输出Prometheus文本格式
+//
+//	metrics := invoke.NewMetrics("invoke")
+//	invoker.Observe(metrics)
+//	http.Handle("/metrics", metrics)
+type Metrics struct {
+	NopObserver
+
+	namespace string
+	buckets   []float64
+
+	mutex       sync.Mutex
+	histograms  map[metricKey]*histogram
+	requests    map[metricKey]uint64
+	errors      map[metricKey]uint64
+	dnsFailures map[metricKey]uint64
+}
+
+// metricKey 指标标签，phase用于直方图，status用于请求计数
+type metricKey struct {
+	host   string
+	path   string
+	phase  string
+	status string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics 创建指标收集器，namespace为指标名前缀，buckets为空时使用 DefaultLatencyBuckets
+func NewMetrics(namespace string, buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	return &Metrics{
+		namespace:   namespace,
+		buckets:     sorted,
+		histograms:  make(map[metricKey]*histogram),
+		requests:    make(map[metricKey]uint64),
+		errors:      make(map[metricKey]uint64),
+		dnsFailures: make(map[metricKey]uint64),
+	}
+}
+
+func (metrics *Metrics) observe(info *ObserveInfo, phase string, d time.Duration) {
+	key := metricKey{host: info.Host, path: info.Route, phase: phase}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	h, exist := metrics.histograms[key]
+	if !exist {
+		h = &histogram{counts: make([]uint64, len(metrics.buckets))}
+		metrics.histograms[key] = h
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range metrics.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (metrics *Metrics) OnDNS(info *ObserveInfo, d time.Duration, err error) {
+	metrics.observe(info, "dns", d)
+}
+
+func (metrics *Metrics) OnConnect(info *ObserveInfo, addr string, d time.Duration, err error) {
+	metrics.observe(info, "connect", d)
+}
+
+func (metrics *Metrics) OnTLS(info *ObserveInfo, d time.Duration, err error) {
+	metrics.observe(info, "tls", d)
+}
+
+func (metrics *Metrics) OnFirstByte(info *ObserveInfo, d time.Duration) {
+	metrics.observe(info, "first_byte", d)
+}
+
+func (metrics *Metrics) OnDone(info *ObserveInfo, d time.Duration, rsp *http.Response, err error) {
+	metrics.observe(info, "total", d)
+
+	status := "error"
+	if rsp != nil {
+		status = strconv.Itoa(rsp.StatusCode)
+	}
+	key := metricKey{host: info.Host, path: info.Route, status: status}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.requests[key]++
+	if err != nil || rsp == nil || rsp.StatusCode >= 500 {
+		metrics.errors[metricKey{host: info.Host, path: info.Route}]++
+	}
+}
+
+// WriteTo 以Prometheus文本格式输出
+func (metrics *Metrics) WriteTo(w io.Writer) (int64, error) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	name := metrics.name("request_phase_duration_seconds")
+	fmt.Fprintf(cw, "# HELP %s Latency of each request phase.\n# TYPE %s histogram\n", name, name)
+	for _, key := range sortedKeys(metrics.histograms) {
+		h := metrics.histograms[key]
+		labels := fmt.Sprintf(`host="%s",path="%s",phase="%s"`, escapeLabel(key.host), escapeLabel(key.path), key.phase)
+		for i, bound := range metrics.buckets {
+			fmt.Fprintf(cw, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(cw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(cw, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(cw, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+
+	name = metrics.name("requests_total")
+	fmt.Fprintf(cw, "# HELP %s Requests by response status.\n# TYPE %s counter\n", name, name)
+	for _, key := range sortedKeys(metrics.requests) {
+		fmt.Fprintf(cw, "%s{host=\"%s\",path=\"%s\",status=\"%s\"} %d\n",
+			name, escapeLabel(key.host), escapeLabel(key.path), key.status, metrics.requests[key])
+	}
+
+	name = metrics.name("errors_total")
+	fmt.Fprintf(cw, "# HELP %s Requests failed with transport error or 5xx status.\n# TYPE %s counter\n", name, name)
+	for _, key := range sortedKeys(metrics.errors) {
+		fmt.Fprintf(cw, "%s{host=\"%s\",path=\"%s\"} %d\n",
+			name, escapeLabel(key.host), escapeLabel(key.path), metrics.errors[key])
+	}
+
+	name = metrics.name("dns_failures_total")
+	fmt.Fprintf(cw, "# HELP %s DNS resolution failures, stale means the last known good IPs were used.\n# TYPE %s counter\n", name, name)
+	for _, key := range sortedKeys(metrics.dnsFailures) {
+		fmt.Fprintf(cw, "%s{host=\"%s\",result=\"%s\"} %d\n",
+			name, escapeLabel(key.host), key.status, metrics.dnsFailures[key])
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// dnsFailed 由 DNSCache 调用
+func (metrics *Metrics) dnsFailed(host string, stale bool) {
+	result := "error"
+	if stale {
+		result = "stale"
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.dnsFailures[metricKey{host: host, status: result}]++
+}
+
+// ServeHTTP 作为 /metrics 的处理器
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteTo(w)
+}
+
+func (metrics *Metrics) name(s string) string {
+	if metrics.namespace == "" {
+		return s
+	}
+	return metrics.namespace + "_" + s
+}
+
+func sortedKeys[V any](m map[metricKey]V) []metricKey {
+	keys := make([]metricKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		if a.phase != b.phase {
+			return a.phase < b.phase
+		}
+		return a.status < b.status
+	})
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
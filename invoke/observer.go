@@ -0,0 +1,126 @@
+package invoke
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ObserveInfo 一次尝试(重试会产生多次尝试)的基本信息
+type ObserveInfo struct {
+	Attempt int // 从0开始
+	Method  string
+	Host    string
+	Path    string
+	Route   string // 路径模板，没有使用模板时与Path相同
+	Start   time.Time
+	Reused  bool // 是否复用了连接，复用时不会有DNS，连接，TLS事件
+}
+
+// Observer 观察请求各个阶段，回调可能在不同的goroutine中并发执行
+type Observer interface {
+	OnStart(info *ObserveInfo)
+	OnDNS(info *ObserveInfo, d time.Duration, err error)
+	OnConnect(info *ObserveInfo, addr string, d time.Duration, err error)
+	OnTLS(info *ObserveInfo, d time.Duration, err error)
+	OnFirstByte(info *ObserveInfo, d time.Duration)
+	OnDone(info *ObserveInfo, d time.Duration, rsp *http.Response, err error)
+}
+
+// NopObserver 空实现，用于嵌入只关心部分事件的 Observer
+type NopObserver struct{}
+
+func (NopObserver) OnStart(*ObserveInfo)                                      {}
+func (NopObserver) OnDNS(*ObserveInfo, time.Duration, error)                  {}
+func (NopObserver) OnConnect(*ObserveInfo, string, time.Duration, error)      {}
+func (NopObserver) OnTLS(*ObserveInfo, time.Duration, error)                  {}
+func (NopObserver) OnFirstByte(*ObserveInfo, time.Duration)                   {}
+func (NopObserver) OnDone(*ObserveInfo, time.Duration, *http.Response, error) {}
+
+// Observe 添加观察者，可以多次调用
+func (invoker *Invoker) Observe(observers ...Observer) *Invoker {
+	list := make([]Observer, 0, len(invoker.observers)+len(observers))
+	list = append(list, invoker.observers...)
+	invoker.observers = append(list, observers...)
+	return invoker
+}
+
+// observeRequest 为请求挂载httptrace，返回新的请求以及结束回调
+func observeRequest(observers []Observer, attempt int, req *http.Request) (*http.Request, func(*http.Response, error)) {
+	if len(observers) == 0 {
+		return req, func(*http.Response, error) {}
+	}
+
+	info := &ObserveInfo{
+		Attempt: attempt,
+		Method:  req.Method,
+		Host:    displayHost(req.URL.Host),
+		Path:    req.URL.Path,
+		Route:   req.URL.Path,
+		Start:   time.Now(),
+	}
+	if invokeInfo := invokeInfoFrom(req); invokeInfo != nil && invokeInfo.route != "" {
+		info.Route = invokeInfo.route
+	}
+
+	// 多个地址可能并发连接
+	var (
+		mutex        sync.Mutex
+		dnsStart     time.Time
+		tlsStart     time.Time
+		connectStart = make(map[string]time.Time, 1)
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(conn httptrace.GotConnInfo) {
+			info.Reused = conn.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(dns httptrace.DNSDoneInfo) {
+			for _, observer := range observers {
+				observer.OnDNS(info, time.Since(dnsStart), dns.Err)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			mutex.Lock()
+			connectStart[addr] = time.Now()
+			mutex.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mutex.Lock()
+			d := time.Since(connectStart[addr])
+			mutex.Unlock()
+
+			for _, observer := range observers {
+				observer.OnConnect(info, addr, d, err)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			for _, observer := range observers {
+				observer.OnTLS(info, time.Since(tlsStart), err)
+			}
+		},
+		GotFirstResponseByte: func() {
+			for _, observer := range observers {
+				observer.OnFirstByte(info, time.Since(info.Start))
+			}
+		},
+	}
+
+	for _, observer := range observers {
+		observer.OnStart(info)
+	}
+
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return req, func(rsp *http.Response, err error) {
+		for _, observer := range observers {
+			observer.OnDone(info, time.Since(info.Start), rsp, err)
+		}
+	}
+}
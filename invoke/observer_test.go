@@ -0,0 +1,45 @@
+package invoke
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics("invoke")
+	template := Addr(strings.TrimPrefix(server.URL, "http://")).Observe(metrics)
+
+	for _, path := range []string{"/ok", "/ok", "/fail"} {
+		rsp, err := template.Copy().Get(path).Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+	}
+
+	var buffer bytes.Buffer
+	metrics.WriteTo(&buffer)
+	out := buffer.String()
+
+	for _, expect := range []string{
+		`invoke_request_phase_duration_seconds_count{host="` + strings.TrimPrefix(server.URL, "http://") + `",path="/ok",phase="total"} 2`,
+		`phase="connect"`,
+		`phase="first_byte"`,
+		`invoke_requests_total{host="` + strings.TrimPrefix(server.URL, "http://") + `",path="/fail",status="500"} 1`,
+		`invoke_errors_total{host="` + strings.TrimPrefix(server.URL, "http://") + `",path="/fail"} 1`,
+	} {
+		if !strings.Contains(out, expect) {
+			t.Fatalf("missing %s in\n%s", expect, out)
+		}
+	}
+}
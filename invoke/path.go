@@ -0,0 +1,64 @@
+package invoke
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrUnresolvedPathParam 路径模板中存在没有取值的参数
+var ErrUnresolvedPathParam = errors.New("invoke: unresolved path param")
+
+// ErrInvalidPathParam 路径参数为空或者为.与..，替换后会改变路径的层级
+var ErrInvalidPathParam = errors.New("invoke: invalid path param")
+
+// PathParam 设置路径模板中{name}的取值，取值按路径段转义，空值以及.与..会使请求失败
+// 没有设置任何参数时路径不会按模板解析，例如
+//
+//	invoke.Addr(addr).Get("/v1/accounts/{accountId}/orders/{orderId}").PathParam("accountId", id).PathParam("orderId", orderId)
+func (invoker *Invoker) PathParam(name string, value interface{}) *Invoker {
+	if invoker.pathParams == nil {
+		invoker.pathParams = make(map[string]string)
+	}
+	invoker.pathParams[name] = fmt.Sprintf("%v", value)
+
+	return invoker
+}
+
+// expandPath 替换路径模板中的参数，存在未设置或不合法的参数，或者括号不完整时返回错误
+func expandPath(template string, params map[string]string) (string, error) {
+	if !strings.Contains(template, "{") {
+		return template, nil
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(template))
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			builder.WriteString(rest)
+			return builder.String(), nil
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", &InvokeError{"ExpandPath", fmt.Errorf("%w: unclosed brace in %q", ErrUnresolvedPathParam, template), false, false}
+		}
+
+		name := rest[start+1 : start+end]
+		value, ok := params[name]
+		if !ok {
+			return "", &InvokeError{"ExpandPath", fmt.Errorf("%w: {%s} in %q", ErrUnresolvedPathParam, name, template), false, false}
+		}
+
+		if value == "" || value == "." || value == ".." {
+			return "", &InvokeError{"ExpandPath", fmt.Errorf("%w: {%s}=%q in %q", ErrInvalidPathParam, name, value, template), false, false}
+		}
+
+		builder.WriteString(rest[:start])
+		builder.WriteString(url.PathEscape(value))
+		rest = rest[start+end+1:]
+	}
+}
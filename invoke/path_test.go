@@ -0,0 +1,70 @@
+package invoke
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type routeObserver struct {
+	NopObserver
+	route string
+}
+
+func (observer *routeObserver) OnDone(info *ObserveInfo, d time.Duration, rsp *http.Response, err error) {
+	observer.route = info.Route
+}
+
+func TestPathTemplate(t *testing.T) {
+	var escaped, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped = r.URL.EscapedPath()
+		path = r.URL.Path
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	observer := &routeObserver{}
+	_, err := Addr(addr).
+		Get("/v1/accounts/{accountId}/orders/{orderId}").
+		PathParam("accountId", "a/b c").
+		PathParam("orderId", 42).
+		Observe(observer).
+		AutoCloseResponseBody().
+		Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escaped != "/v1/accounts/a%2Fb%20c/orders/42" {
+		t.Fatalf("unexpected path %s", escaped)
+	}
+	if observer.route != "/v1/accounts/{accountId}/orders/{orderId}" {
+		t.Fatalf("unexpected route %s", observer.route)
+	}
+
+	for _, template := range []string{"/v1/accounts/{accountId}/orders/{orderId}", "/v1/accounts/{accountId"} {
+		_, err = Addr(addr).Get(template).PathParam("accountId", "1").Request()
+		if !errors.Is(err, ErrUnresolvedPathParam) {
+			t.Fatalf("%s expect unresolved error, got %v", template, err)
+		}
+	}
+
+	// 参数不能改变路径的层级
+	for _, value := range []string{"", ".", ".."} {
+		_, err = Addr(addr).Get("/v1/accounts/{accountId}/orders").PathParam("accountId", value).Request()
+		if !errors.Is(err, ErrInvalidPathParam) {
+			t.Fatalf("%q expect invalid error, got %v", value, err)
+		}
+	}
+	if _, err = Addr(addr).Get("/v1/accounts/{accountId}").PathParam("accountId", "..a").AutoCloseResponseBody().Request(); err != nil || escaped != "/v1/accounts/..a" {
+		t.Fatalf("unexpected path %s %v", escaped, err)
+	}
+
+	// 没有路径参数时括号原样保留
+	if _, err = Addr(addr).Get("/v1/labels/{raw}").AutoCloseResponseBody().Request(); err != nil || path != "/v1/labels/{raw}" {
+		t.Fatalf("unexpected path %s %v", path, err)
+	}
+}
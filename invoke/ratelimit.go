@@ -0,0 +1,198 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited 快速失败模式下令牌不足
+var ErrRateLimited = errors.New("invoke: rate limited")
+
+// RateLimiter 客户端令牌桶限流，默认按请求的host分桶
+// 令牌在window内匀速恢复，最多积累limit个
+//
+//	limiter := invoke.NewRateLimiter(1200, time.Minute).UsedWeightHeader("X-Mbx-Used-Weight-1m")
+//	invoker.RateLimit(limiter).RequestWeight(5)
+type RateLimiter struct {
+	limit      float64
+	rate       float64 // 每秒恢复的令牌
+	keyFunc    func(req *http.Request) string
+	failFast   bool
+	usedHeader string
+	now        func() time.Time
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// NewRateLimiter 每个桶在window内最多允许limit的权重
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   float64(limit),
+		rate:    float64(limit) / window.Seconds(),
+		keyFunc: func(req *http.Request) string { return req.URL.Host },
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// KeyBy 自定义分桶的键，例如按API key
+func (limiter *RateLimiter) KeyBy(fn func(req *http.Request) string) *RateLimiter {
+	limiter.keyFunc = fn
+	return limiter
+}
+
+// KeyByHeader 按请求头部分桶
+func (limiter *RateLimiter) KeyByHeader(header string) *RateLimiter {
+	return limiter.KeyBy(func(req *http.Request) string {
+		return req.Header.Get(header)
+	})
+}
+
+// FailFast 令牌不足时立即返回 ErrRateLimited，默认等待令牌直到context结束
+func (limiter *RateLimiter) FailFast() *RateLimiter {
+	limiter.failFast = true
+	return limiter
+}
+
+// UsedWeightHeader 上游通过该头部返回当前窗口已使用的权重，据此校正剩余令牌
+func (limiter *RateLimiter) UsedWeightHeader(header string) *RateLimiter {
+	limiter.usedHeader = header
+	return limiter
+}
+
+// RateLimit 启用限流，缓存命中与合并的请求不消耗令牌
+func (invoker *Invoker) RateLimit(limiter *RateLimiter) *Invoker {
+	invoker.rateLimiter = limiter
+	return invoker
+}
+
+// RequestWeight 请求的权重，默认1
+func (invoker *Invoker) RequestWeight(weight int) *Invoker {
+	invoker.requestWeight = weight
+	return invoker
+}
+
+// bucket 调用方持有锁
+func (limiter *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.limit, last: now}
+		limiter.buckets[key] = bucket
+		return bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * limiter.rate
+	if bucket.tokens > limiter.limit {
+		bucket.tokens = limiter.limit
+	}
+	bucket.last = now
+	return bucket
+}
+
+// reserve 预留weight个令牌，返回需要等待的时间；快速失败模式下令牌不足时不预留
+func (limiter *RateLimiter) reserve(key string, weight float64) (time.Duration, bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	bucket := limiter.bucket(key, now)
+
+	var wait time.Duration
+	if bucket.blockedUntil.After(now) {
+		wait = bucket.blockedUntil.Sub(now)
+	}
+	if deficit := weight - bucket.tokens; deficit > 0 {
+		if refill := time.Duration(deficit / limiter.rate * float64(time.Second)); refill > wait {
+			wait = refill
+		}
+	}
+
+	if wait > 0 && limiter.failFast {
+		return wait, false
+	}
+	bucket.tokens -= weight
+	return wait, true
+}
+
+func (limiter *RateLimiter) cancel(key string, weight float64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.bucket(key, limiter.now()).tokens += weight
+}
+
+// Wait 取得weight个令牌
+func (limiter *RateLimiter) Wait(ctx context.Context, key string, weight int) error {
+	wait, ok := limiter.reserve(key, float64(weight))
+	if !ok {
+		return &InvokeError{"RateLimit", ErrRateLimited, true, false}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		limiter.cancel(key, float64(weight))
+		return &InvokeError{"RateLimit", ctx.Err(), false, errors.Is(ctx.Err(), context.DeadlineExceeded)}
+	}
+}
+
+// observe 按上游返回的已用权重与Retry-After校正令牌桶
+func (limiter *RateLimiter) observe(key string, rsp *http.Response) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	bucket := limiter.bucket(key, now)
+
+	if limiter.usedHeader != "" {
+		if used, err := strconv.ParseFloat(rsp.Header.Get(limiter.usedHeader), 64); err == nil {
+			if remain := limiter.limit - used; remain < bucket.tokens {
+				bucket.tokens = remain
+			}
+		}
+	}
+
+	// 429以及币安的418表示已经超限，按Retry-After暂停该桶
+	if rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode == http.StatusTeapot {
+		if seconds, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			if until := now.Add(time.Duration(seconds) * time.Second); until.After(bucket.blockedUntil) {
+				bucket.blockedUntil = until
+			}
+		}
+	}
+}
+
+// do 取得令牌后发送请求
+func (limiter *RateLimiter) do(req *http.Request, weight int, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	key := limiter.keyFunc(req)
+	if err := limiter.Wait(req.Context(), key, weight); err != nil {
+		return nil, err
+	}
+
+	rsp, err := do(req)
+	if err == nil {
+		limiter.observe(key, rsp)
+	}
+	return rsp, err
+}
@@ -0,0 +1,72 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/heavy" {
+			w.Header().Set("X-Used-Weight", "100")
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	// 快速失败
+	limiter := NewRateLimiter(3, time.Minute).FailFast()
+	for i := 0; i < 2; i++ {
+		if _, err := Addr(addr).Get("/").RateLimit(limiter).AutoCloseResponseBody().Request(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := Addr(addr).Get("/").RateLimit(limiter).RequestWeight(2).AutoCloseResponseBody().Request()
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expect rate limited, got %v", err)
+	}
+
+	// 按头部分桶
+	keyed := NewRateLimiter(1, time.Minute).FailFast().KeyByHeader("X-Api-Key")
+	for _, key := range []string{"a", "b"} {
+		if _, err := Addr(addr).Get("/").Header("X-Api-Key", key).RateLimit(keyed).AutoCloseResponseBody().Request(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 阻塞等待令牌
+	blocking := NewRateLimiter(10, time.Second)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		if _, err := Addr(addr).Get("/").RateLimit(blocking).AutoCloseResponseBody().Request(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cost := time.Since(start); cost < 80*time.Millisecond {
+		t.Fatalf("expect waiting for token, cost %v", cost)
+	}
+
+	// 等待受context限制
+	slow := NewRateLimiter(1, time.Minute)
+	Addr(addr).Get("/").RateLimit(slow).AutoCloseResponseBody().Request()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Addr(addr).Get("/").RateLimit(slow).Context(ctx).AutoCloseResponseBody().Request(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+
+	// 按上游返回的已用权重校正
+	adaptive := NewRateLimiter(100, time.Minute).FailFast().UsedWeightHeader("X-Used-Weight")
+	if _, err := Addr(addr).Get("/heavy").RateLimit(adaptive).AutoCloseResponseBody().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Addr(addr).Get("/").RateLimit(adaptive).AutoCloseResponseBody().Request(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expect rate limited after used weight, got %v", err)
+	}
+}
@@ -0,0 +1,396 @@
+package invoke
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+)
+
+// ServicePrefix Addr以该前缀开头时，其余部分作为逻辑服务名通过 Resolver 解析，例如 Addr("service://orders")
+const ServicePrefix = "service://"
+
+// ErrServiceNotFound 解析器中没有该服务或服务没有地址
+var ErrServiceNotFound = errors.New("invoke: service not found")
+
+// Resolver 把逻辑服务名解析为一组host:port地址
+type Resolver interface {
+	Resolve(ctx context.Context, service string) ([]string, error)
+}
+
+// WatchableResolver 能主动通知变化的解析器，变化时 Discovery 立即重新解析
+// Watch 返回取消订阅的函数，解析器被替换时调用
+type WatchableResolver interface {
+	Resolver
+	Watch(onChange func()) (unwatch func())
+}
+
+// ResolverFunc 函数形式的 Resolver
+type ResolverFunc func(ctx context.Context, service string) ([]string, error)
+
+func (fn ResolverFunc) Resolve(ctx context.Context, service string) ([]string, error) {
+	return fn(ctx, service)
+}
+
+// StaticResolver 固定的服务地址表，适合测试中替代注册中心
+type StaticResolver map[string][]string
+
+func (resolver StaticResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	addrs := resolver[service]
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, service)
+	}
+	return append([]string(nil), addrs...), nil
+}
+
+// FileResolver 从本地TOML或JSON文件读取服务地址表，按扩展名选择格式，文件内容形如
+//
+//	orders = ["10.0.0.1:8080", "10.0.0.2:8080"]
+//
+// 文件内容变化后自动重新加载并通知 Discovery
+type FileResolver struct {
+	path string
+
+	mutex       sync.RWMutex
+	services    map[string][]string
+	size        int
+	sum         [sha256.Size]byte
+	watchers    map[int]func()
+	nextWatcher int
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewFileResolver 加载文件并每隔interval检查一次修改
+func NewFileResolver(path string, interval time.Duration) (*FileResolver, error) {
+	resolver := &FileResolver{path: path, stop: make(chan struct{})}
+	if _, err := resolver.reload(); err != nil {
+		return nil, err
+	}
+
+	go resolver.poll(interval)
+	return resolver, nil
+}
+
+func (resolver *FileResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	resolver.mutex.RLock()
+	defer resolver.mutex.RUnlock()
+
+	addrs := resolver.services[service]
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, service)
+	}
+	return append([]string(nil), addrs...), nil
+}
+
+func (resolver *FileResolver) Watch(onChange func()) func() {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	if resolver.watchers == nil {
+		resolver.watchers = make(map[int]func())
+	}
+	id := resolver.nextWatcher
+	resolver.nextWatcher++
+	resolver.watchers[id] = onChange
+
+	return func() {
+		resolver.mutex.Lock()
+		defer resolver.mutex.Unlock()
+
+		delete(resolver.watchers, id)
+	}
+}
+
+// Close 停止检查文件
+func (resolver *FileResolver) Close() {
+	resolver.once.Do(func() { close(resolver.stop) })
+}
+
+func (resolver *FileResolver) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-resolver.stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := resolver.reload()
+		if err != nil {
+			// 文件写了一半或格式错误时继续使用上一次的内容
+			logrus.WithError(err).WithField("path", resolver.path).Warnln("FileResolverReloadFailed")
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		resolver.mutex.RLock()
+		watchers := make([]func(), 0, len(resolver.watchers))
+		for _, watcher := range resolver.watchers {
+			watchers = append(watchers, watcher)
+		}
+		resolver.mutex.RUnlock()
+		for _, watcher := range watchers {
+			watcher()
+		}
+	}
+}
+
+// reload 文件长度或内容摘要变化时重新加载，修改时间的精度不足以发现快速的连续修改
+func (resolver *FileResolver) reload() (bool, error) {
+	b, err := os.ReadFile(resolver.path)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(b)
+
+	resolver.mutex.RLock()
+	unchanged := resolver.services != nil && len(b) == resolver.size && sum == resolver.sum
+	resolver.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	services := make(map[string][]string)
+	switch strings.ToLower(filepath.Ext(resolver.path)) {
+	case ".json":
+		err = json.Unmarshal(b, &services)
+	default:
+		err = toml.Unmarshal(b, &services)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	resolver.mutex.Lock()
+	resolver.services = services
+	resolver.size = len(b)
+	resolver.sum = sum
+	resolver.mutex.Unlock()
+	return true, nil
+}
+
+// SRVResolver 通过DNS SRV记录解析，服务名为完整的SRV名称，例如 _http._tcp.orders.svc.cluster.local
+type SRVResolver struct {
+	// Resolver 为空时使用 net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+func (resolver *SRVResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	r := resolver.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+
+	_, records, err := r.LookupSRV(ctx, "", "", service)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, service)
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return addrs, nil
+}
+
+// Discovery 缓存服务的解析结果，每个服务对应一个 Upstream，地址变化通过 Upstream.SetAddrs 传递给使用中的invoker
+// 缓存过期后先继续使用旧地址并在后台重新解析，解析失败时保留旧地址
+type Discovery struct {
+	ttl       time.Duration
+	configure func(service string, upstream *Upstream)
+
+	mutex      sync.Mutex
+	resolver   Resolver
+	unwatch    func() // 取消对当前解析器的订阅
+	generation int    // 每次替换解析器时递增
+	services   map[string]*discoveredService
+}
+
+type discoveredService struct {
+	upstream   *Upstream
+	resolved   time.Time
+	refreshing bool
+}
+
+// DefaultDiscovery Addr使用 ServicePrefix 且invoker没有指定 Discovery 时使用，默认没有解析器
+var DefaultDiscovery = NewDiscovery(nil)
+
+// SetResolver 替换 DefaultDiscovery 的解析器
+func SetResolver(resolver Resolver) {
+	DefaultDiscovery.SetResolver(resolver)
+}
+
+// NewDiscovery 解析结果默认缓存30秒
+func NewDiscovery(resolver Resolver) *Discovery {
+	discovery := &Discovery{
+		ttl:      30 * time.Second,
+		services: make(map[string]*discoveredService),
+	}
+	discovery.SetResolver(resolver)
+	return discovery
+}
+
+// TTL 解析结果的缓存时间
+func (discovery *Discovery) TTL(ttl time.Duration) *Discovery {
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+
+	discovery.ttl = ttl
+	return discovery
+}
+
+// Configure 服务的 Upstream 创建后调用，用于设置负载均衡器，摘除策略等
+func (discovery *Discovery) Configure(fn func(service string, upstream *Upstream)) *Discovery {
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+
+	discovery.configure = fn
+	return discovery
+}
+
+// SetResolver 替换解析器并清空缓存，之后的请求使用新的解析器
+func (discovery *Discovery) SetResolver(resolver Resolver) {
+	discovery.mutex.Lock()
+	discovery.resolver = resolver
+	discovery.generation++
+	generation := discovery.generation
+	discovery.services = make(map[string]*discoveredService)
+	unwatch := discovery.unwatch
+	discovery.unwatch = nil
+	discovery.mutex.Unlock()
+
+	if unwatch != nil {
+		unwatch()
+	}
+
+	watchable, ok := resolver.(WatchableResolver)
+	if !ok {
+		return
+	}
+	unwatch = watchable.Watch(func() {
+		discovery.refreshAll(generation)
+	})
+
+	discovery.mutex.Lock()
+	current := discovery.generation == generation
+	if current {
+		discovery.unwatch = unwatch
+	}
+	discovery.mutex.Unlock()
+
+	// 订阅期间解析器又被替换
+	if !current {
+		unwatch()
+	}
+}
+
+// Discovery 使用指定的 Discovery 解析 ServicePrefix 地址
+func (invoker *Invoker) Discovery(discovery *Discovery) *Invoker {
+	invoker.discovery = discovery
+	return invoker
+}
+
+// Upstream 返回服务对应的 Upstream，第一次解析同步进行
+func (discovery *Discovery) Upstream(ctx context.Context, service string) (*Upstream, error) {
+	discovery.mutex.Lock()
+	resolver, generation := discovery.resolver, discovery.generation
+	if s, ok := discovery.services[service]; ok {
+		if !s.refreshing && time.Since(s.resolved) > discovery.ttl {
+			s.refreshing = true
+			go discovery.refresh(resolver, service, s)
+		}
+		discovery.mutex.Unlock()
+		return s.upstream, nil
+	}
+	discovery.mutex.Unlock()
+
+	if resolver == nil {
+		return nil, fmt.Errorf("%w: no resolver for %s", ErrServiceNotFound, service)
+	}
+
+	addrs, err := resolver.Resolve(ctx, service)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("%w: %s", ErrServiceNotFound, service)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+
+	// 并发的第一次解析只保留一个
+	if s, ok := discovery.services[service]; ok {
+		return s.upstream, nil
+	}
+	if discovery.generation != generation {
+		return NewUpstream(addrs...), nil
+	}
+
+	upstream := NewUpstream(addrs...)
+	if discovery.configure != nil {
+		discovery.configure(service, upstream)
+	}
+	discovery.services[service] = &discoveredService{upstream: upstream, resolved: time.Now()}
+	return upstream, nil
+}
+
+func (discovery *Discovery) refresh(resolver Resolver, service string, s *discoveredService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := resolver.Resolve(ctx, service)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("%w: %s", ErrServiceNotFound, service)
+	}
+
+	if err != nil {
+		logrus.WithError(err).WithField("service", service).Warnln("ResolveServiceFailed")
+	} else {
+		s.upstream.SetAddrs(addrs...)
+	}
+
+	discovery.mutex.Lock()
+	s.resolved = time.Now()
+	s.refreshing = false
+	discovery.mutex.Unlock()
+}
+
+// refreshAll 解析器通知变化时重新解析所有已缓存的服务
+func (discovery *Discovery) refreshAll(generation int) {
+	discovery.mutex.Lock()
+	if discovery.generation != generation {
+		discovery.mutex.Unlock()
+		return
+	}
+	resolver := discovery.resolver
+	services := make(map[string]*discoveredService, len(discovery.services))
+	for name, s := range discovery.services {
+		services[name] = s
+	}
+	discovery.mutex.Unlock()
+
+	for name, s := range services {
+		discovery.refresh(resolver, name, s)
+	}
+}
@@ -0,0 +1,110 @@
+package invoke
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func namedServer(t *testing.T, name string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func requestName(t *testing.T, invoker *Invoker) string {
+	t.Helper()
+
+	rsp, err := invoker.Get("/").Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	b, _ := ioutil.ReadAll(rsp.Body)
+	return string(b)
+}
+
+func TestStaticResolver(t *testing.T) {
+	SetResolver(StaticResolver{"orders": {namedServer(t, "a")}})
+	t.Cleanup(func() { SetResolver(nil) })
+
+	if name := requestName(t, Addr(ServicePrefix+"orders")); name != "a" {
+		t.Fatalf("unexpected server %s", name)
+	}
+
+	_, err := Addr(ServicePrefix + "missing").Get("/").Request()
+	if !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expect service not found, got %v", err)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	a, b := namedServer(t, "a"), namedServer(t, "b")
+
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := os.WriteFile(path, []byte(`{"orders":["`+a+`"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewFileResolver(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Close()
+
+	invoker := Addr(ServicePrefix + "orders").Discovery(NewDiscovery(resolver))
+	if name := requestName(t, invoker.Copy()); name != "a" {
+		t.Fatalf("unexpected server %s", name)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"orders":["`+b+`"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for requestName(t, invoker.Copy()) != "b" {
+		if time.Now().After(deadline) {
+			t.Fatal("file change not propagated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetResolverUnwatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.toml")
+	if err := os.WriteFile(path, []byte(`orders = ["127.0.0.1:8080"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewFileResolver(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Close()
+
+	watchers := func() int {
+		resolver.mutex.RLock()
+		defer resolver.mutex.RUnlock()
+		return len(resolver.watchers)
+	}
+
+	discovery := NewDiscovery(resolver)
+	discovery.SetResolver(resolver)
+	if n := watchers(); n != 1 {
+		t.Fatalf("replaced resolver should be unwatched, watchers=%d", n)
+	}
+
+	discovery.SetResolver(StaticResolver{})
+	if n := watchers(); n != 0 {
+		t.Fatalf("replaced resolver should be unwatched, watchers=%d", n)
+	}
+}
@@ -0,0 +1,280 @@
+package invoke
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer 在请求的URL，头部，请求体都确定之后对请求签名
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignerFunc 函数形式的 Signer
+type SignerFunc func(req *http.Request, body []byte) error
+
+func (fn SignerFunc) Sign(req *http.Request, body []byte) error {
+	return fn(req, body)
+}
+
+// HmacSigner 交易所风格的HMAC-SHA256签名
+// 待签名串按 时间戳,方法,路径,查询串,请求体 的顺序拼接，每一部分是否参与由Include*控制
+//
+// 例如Binance: TimestampParam="timestamp",RecvWindowParam="recvWindow",IncludeQuery,IncludeBody,SignatureParam="signature"
+// 例如FTX: TimestampHeader="FTX-TS",IncludeTimestamp,IncludeMethod,IncludePath,IncludeQuery,IncludeBody,SignatureHeader="FTX-SIGN"
+type HmacSigner struct {
+	Secret    string
+	Key       string
+	KeyHeader string // 放置Key的头部
+
+	TimestampParam  string // 毫秒时间戳放入的查询参数
+	TimestampHeader string // 毫秒时间戳放入的头部
+	RecvWindowParam string
+	RecvWindow      time.Duration
+
+	SortQuery        bool // 按参数名排序查询串，否则保持原始顺序
+	IncludeTimestamp bool
+	IncludeMethod    bool
+	IncludePath      bool
+	IncludeQuery     bool
+	IncludeBody      bool
+
+	SignatureParam  string // 签名放入的查询参数
+	SignatureHeader string // 签名放入的头部
+	Base64          bool   // 签名使用base64编码，默认hex
+
+	Now func() time.Time
+}
+
+func (signer *HmacSigner) Sign(req *http.Request, body []byte) error {
+	if signer.SignatureParam == "" && signer.SignatureHeader == "" {
+		return fmt.Errorf("hmac signer: no signature destination")
+	}
+
+	now := time.Now
+	if signer.Now != nil {
+		now = signer.Now
+	}
+	ts := strconv.FormatInt(now().UnixNano()/int64(time.Millisecond), 10)
+
+	query := req.URL.RawQuery
+	if signer.RecvWindowParam != "" && signer.RecvWindow > 0 {
+		query = appendQuery(query, signer.RecvWindowParam,
+			strconv.FormatInt(int64(signer.RecvWindow/time.Millisecond), 10))
+	}
+	if signer.TimestampParam != "" {
+		query = appendQuery(query, signer.TimestampParam, ts)
+	}
+	if signer.SortQuery {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return fmt.Errorf("hmac signer: parse query:%v", err)
+		}
+		query = values.Encode()
+	}
+
+	if signer.TimestampHeader != "" {
+		req.Header.Set(signer.TimestampHeader, ts)
+	}
+	if signer.KeyHeader != "" {
+		req.Header.Set(signer.KeyHeader, signer.Key)
+	}
+
+	var builder strings.Builder
+	if signer.IncludeTimestamp {
+		builder.WriteString(ts)
+	}
+	if signer.IncludeMethod {
+		builder.WriteString(req.Method)
+	}
+	if signer.IncludePath {
+		builder.WriteString(req.URL.EscapedPath())
+		if signer.IncludeQuery && query != "" {
+			builder.WriteByte('?')
+		}
+	}
+	if signer.IncludeQuery {
+		builder.WriteString(query)
+	}
+	if signer.IncludeBody {
+		builder.Write(body)
+	}
+
+	mac := hmac.New(sha256.New, []byte(signer.Secret))
+	mac.Write([]byte(builder.String()))
+	sum := mac.Sum(nil)
+
+	var signature string
+	if signer.Base64 {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	} else {
+		signature = hex.EncodeToString(sum)
+	}
+
+	if signer.SignatureHeader != "" {
+		req.Header.Set(signer.SignatureHeader, signature)
+	}
+	if signer.SignatureParam != "" {
+		query = appendQuery(query, signer.SignatureParam, signature)
+	}
+
+	req.URL.RawQuery = query
+	return nil
+}
+
+func appendQuery(query, key, value string) string {
+	if query != "" {
+		query += "&"
+	}
+	return query + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// SigV4Signer AWS Signature Version 4 签名
+// 固定签名 host,x-amz-date,x-amz-security-token(如有) 以及 SignedHeaders 中列出的头部
+type SigV4Signer struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+
+	SignedHeaders []string
+	ContentSha256 bool // 设置x-amz-content-sha256头部，S3需要
+	UnsignedBody  bool // 请求体不参与签名，使用UNSIGNED-PAYLOAD
+	Now           func() time.Time
+}
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+func (signer *SigV4Signer) Sign(req *http.Request, body []byte) error {
+	now := time.Now
+	if signer.Now != nil {
+		now = signer.Now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if !signer.UnsignedBody {
+		payloadHash = sha256Hex(body)
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if signer.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", signer.SessionToken)
+	}
+	if signer.ContentSha256 {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		lower := strings.ToLower(key)
+		if !strings.HasPrefix(lower, "x-amz-") && !containsFold(signer.SignedHeaders, lower) {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[lower] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(headers[name])
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		sigV4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + signer.Region + "/" + signer.Service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+signer.SecretKey), date)
+	key = hmacSha256(key, signer.Region)
+	key = hmacSha256(key, signer.Service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, signer.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func sigV4CanonicalQuery(values url.Values) string {
+	pairs := make([]string, 0, len(values))
+	for key, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape 按RFC3986编码，仅保留 A-Za-z0-9-_.~
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,83 @@
+package invoke
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHmacSignerBinance(t *testing.T) {
+	signer := &HmacSigner{
+		Secret:          "NhqPtmdSJYdKjVHjA7PZj4Mge3R5YNiP1e3UZjInClVN65XAbvqqM6A7H5fATj0j",
+		Key:             "vmPUZE6mv9SD5VNHk4HlWFsOr6aKE2zvsw0MuIgwCIPy6utIco14y7Ju91duEh8A",
+		KeyHeader:       "X-MBX-APIKEY",
+		TimestampParam:  "timestamp",
+		RecvWindowParam: "recvWindow",
+		RecvWindow:      5 * time.Second,
+		IncludeQuery:    true,
+		IncludeBody:     true,
+		SignatureParam:  "signature",
+		Now:             func() time.Time { return time.Unix(0, 1499827319559*int64(time.Millisecond)) },
+	}
+
+	req, _ := http.NewRequest("POST",
+		"https://api.binance.com/api/v3/order?symbol=LTCBTC&side=BUY&type=LIMIT&timeInForce=GTC&quantity=1&price=0.1", nil)
+	if err := signer.Sign(req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "symbol=LTCBTC&side=BUY&type=LIMIT&timeInForce=GTC&quantity=1&price=0.1&recvWindow=5000&timestamp=1499827319559" +
+		"&signature=c8db56825ae71d6d79447849e617115f4a920fa2acdcab2b053c4b2838bd6b71"
+	if req.URL.RawQuery != expect {
+		t.Fatalf("got query %s", req.URL.RawQuery)
+	}
+	if req.Header.Get("X-MBX-APIKEY") != signer.Key {
+		t.Fatal("api key header missing")
+	}
+
+	// 相同的参数放在请求体中，签名一致
+	req, _ = http.NewRequest("POST", "https://api.binance.com/api/v3/order", nil)
+	signer.TimestampParam, signer.RecvWindowParam, signer.SignatureParam = "", "", ""
+	signer.SignatureHeader = "Signature"
+	body := "symbol=LTCBTC&side=BUY&type=LIMIT&timeInForce=GTC&quantity=1&price=0.1&recvWindow=5000&timestamp=1499827319559"
+	if err := signer.Sign(req, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Signature") != "c8db56825ae71d6d79447849e617115f4a920fa2acdcab2b053c4b2838bd6b71" {
+		t.Fatalf("got signature %s", req.Header.Get("Signature"))
+	}
+}
+
+func TestSigV4Signer(t *testing.T) {
+	signer := &SigV4Signer{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+		Service:   "service",
+		Now:       func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+
+	cases := []struct {
+		url       string
+		signature string
+	}{
+		{"https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", c.url, nil)
+		if err := signer.Sign(req, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, ") {
+			t.Fatalf("got authorization %s", auth)
+		}
+		if !strings.HasSuffix(auth, "Signature="+c.signature) {
+			t.Fatalf("%s got authorization %s", c.url, auth)
+		}
+	}
+}
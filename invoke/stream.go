@@ -0,0 +1,480 @@
+package invoke
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrIdleTimeout  = errors.New("stream idle timeout")
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+// streamConn 一个流式响应的连接，生命周期由调用方的context控制，而不是 Timeout
+// 空闲超时只计算阻塞在读取上的时间
+type streamConn struct {
+	cancel context.CancelFunc
+	body   io.ReadCloser
+	reader *bufio.Reader
+
+	idle     time.Duration
+	timer    *time.Timer
+	mutex    sync.Mutex
+	idleHit  bool
+	closeHit bool
+}
+
+// openStream 使用invoker的副本发起请求，超时由idle控制，非200状态视为失败
+func openStream(ctx context.Context, invoker *Invoker, idle time.Duration, headers map[string]string) (*streamConn, *http.Response, error) {
+	connCtx, cancel := context.WithCancel(ctx)
+
+	// 流式响应不能被缓存，合并或对冲
+	in := invoker.Copy()
+	in.ctx = connCtx
+	in.timeout = 0
+	in.closeBodyAfterRequest = false
+	in.cache = nil
+	in.coalescer = nil
+	in.hedge = nil
+	for key, value := range headers {
+		in.Header(key, value)
+	}
+
+	conn := &streamConn{cancel: cancel, idle: idle}
+	if idle > 0 {
+		// 连接建立也受空闲超时的限制
+		conn.timer = time.AfterFunc(idle, conn.expire)
+	}
+
+	rsp, err := in.Request()
+	if err == nil && rsp.StatusCode != http.StatusOK {
+		err = &InvokeError{Action: "OpenStream", Err: fmt.Errorf("status=%d", rsp.StatusCode)}
+	}
+	if err != nil {
+		if rsp != nil && rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		conn.stop()
+		cancel()
+		return nil, rsp, conn.wrapErr(err)
+	}
+
+	conn.stop()
+	conn.body = rsp.Body
+	conn.reader = bufio.NewReader(conn)
+	return conn, rsp, nil
+}
+
+func (conn *streamConn) expire() {
+	conn.mutex.Lock()
+	conn.idleHit = true
+	conn.mutex.Unlock()
+	conn.cancel()
+}
+
+func (conn *streamConn) stop() {
+	if conn.timer != nil {
+		conn.timer.Stop()
+	}
+}
+
+func (conn *streamConn) Read(p []byte) (int, error) {
+	if conn.timer != nil {
+		conn.timer.Reset(conn.idle)
+	}
+	n, err := conn.body.Read(p)
+	conn.stop()
+	if err != nil {
+		err = conn.wrapErr(err)
+	}
+	return n, err
+}
+
+// wrapErr 区分空闲超时和主动关闭导致的错误
+func (conn *streamConn) wrapErr(err error) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	switch {
+	case conn.closeHit:
+		return ErrStreamClosed
+	case conn.idleHit:
+		return &InvokeError{Action: "ReadStream", Err: ErrIdleTimeout, IsTimeout: true}
+	}
+	return err
+}
+
+func (conn *streamConn) Close() error {
+	conn.mutex.Lock()
+	conn.closeHit = true
+	conn.mutex.Unlock()
+
+	conn.stop()
+	conn.cancel()
+	return conn.body.Close()
+}
+
+// Event Server-Sent Events 中的一个事件
+type Event struct {
+	ID    string
+	Event string
+	Data  []byte
+	Retry time.Duration
+}
+
+// EventStream Server-Sent Events 迭代器，断开后自动重连并携带 Last-Event-ID
+//
+//	stream := invoker.SSE(ctx)
+//	defer stream.Close()
+//	for stream.Next() {
+//		event := stream.Event()
+//	}
+//	err := stream.Err()
+type EventStream struct {
+	ctx     context.Context
+	invoker *Invoker
+
+	idle          time.Duration
+	retryDelay    time.Duration
+	maxReconnects int
+	reconnects    int
+
+	mutex       sync.Mutex
+	conn        *streamConn
+	closed      bool
+	lastEventID string
+	event       Event
+	fatal       error
+	err         error
+}
+
+// SSE 以Server-Sent Events方式消费响应，连接在第一次 Next 时建立
+// 流的生命周期由ctx控制，invoker的 Timeout 不生效
+func (invoker *Invoker) SSE(ctx context.Context) *EventStream {
+	return &EventStream{
+		ctx:           ctx,
+		invoker:       invoker.Copy(),
+		retryDelay:    3 * time.Second,
+		maxReconnects: -1,
+	}
+}
+
+// IdleTimeout 超过d没有读到数据则断开，允许重连时会重连
+func (stream *EventStream) IdleTimeout(d time.Duration) *EventStream {
+	stream.idle = d
+	return stream
+}
+
+// Reconnect 最多连续重连max次，小于0不限制，delay为默认重连间隔，服务端的retry字段会覆盖它
+func (stream *EventStream) Reconnect(max int, delay time.Duration) *EventStream {
+	stream.maxReconnects = max
+	stream.retryDelay = delay
+	return stream
+}
+
+// LastEventID 从指定事件之后开始消费
+func (stream *EventStream) LastEventID(id string) *EventStream {
+	stream.lastEventID = id
+	return stream
+}
+
+// Next 读取下一个事件，返回false时通过 Err 查看原因
+func (stream *EventStream) Next() bool {
+	for {
+		conn, err := stream.connect()
+		if err == nil {
+			var event Event
+			event, err = readEvent(conn.reader)
+			if err == nil {
+				stream.reconnects = 0
+				if event.ID != "" {
+					stream.lastEventID = event.ID
+				}
+				if event.Retry > 0 {
+					stream.retryDelay = event.Retry
+				}
+				if event.Data == nil {
+					continue
+				}
+				stream.event = event
+				return true
+			}
+
+			conn.Close()
+			stream.mutex.Lock()
+			stream.conn = nil
+			stream.mutex.Unlock()
+		}
+
+		if stopErr := stream.backoff(err); stopErr != nil {
+			if stopErr != ErrStreamClosed && stopErr != io.EOF {
+				stream.err = stopErr
+			}
+			return false
+		}
+	}
+}
+
+func (stream *EventStream) connect() (*streamConn, error) {
+	stream.mutex.Lock()
+	if stream.closed {
+		stream.mutex.Unlock()
+		return nil, ErrStreamClosed
+	}
+	if stream.conn != nil {
+		conn := stream.conn
+		stream.mutex.Unlock()
+		return conn, nil
+	}
+	stream.mutex.Unlock()
+
+	headers := map[string]string{
+		"Accept":        "text/event-stream",
+		"Cache-Control": "no-cache",
+	}
+	if stream.lastEventID != "" {
+		headers["Last-Event-ID"] = stream.lastEventID
+	}
+
+	conn, rsp, err := openStream(stream.ctx, stream.invoker, stream.idle, headers)
+	if err != nil {
+		// 客户端错误重连也无法恢复
+		if rsp != nil && rsp.StatusCode >= 400 && rsp.StatusCode < 500 {
+			stream.fatal = err
+		}
+		return nil, err
+	}
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.closed {
+		conn.Close()
+		return nil, ErrStreamClosed
+	}
+	stream.conn = conn
+	return conn, nil
+}
+
+// backoff 等待重连，返回非nil表示不再重连
+func (stream *EventStream) backoff(err error) error {
+	stream.mutex.Lock()
+	closed := stream.closed
+	stream.mutex.Unlock()
+
+	switch {
+	case closed || err == ErrStreamClosed:
+		return ErrStreamClosed
+	case stream.fatal != nil:
+		return stream.fatal
+	case stream.ctx.Err() != nil:
+		return stream.ctx.Err()
+	case stream.maxReconnects >= 0 && stream.reconnects >= stream.maxReconnects:
+		return err
+	}
+	stream.reconnects++
+
+	timer := time.NewTimer(stream.retryDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-stream.ctx.Done():
+		return stream.ctx.Err()
+	}
+}
+
+// Event 当前事件
+func (stream *EventStream) Event() Event {
+	return stream.event
+}
+
+// Err 迭代结束的原因，正常结束或主动关闭时为nil
+func (stream *EventStream) Err() error {
+	return stream.err
+}
+
+// Close 关闭流，可以在其他goroutine中调用以打断 Next
+func (stream *EventStream) Close() error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.closed = true
+	if stream.conn != nil {
+		return stream.conn.Close()
+	}
+	return nil
+}
+
+// readEvent 按照SSE规范读取一个事件，注释与空事件的Data为nil
+func readEvent(reader *bufio.Reader) (Event, error) {
+	var (
+		event Event
+		data  bytes.Buffer
+		seen  bool
+	)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if seen {
+				event.Data = bytes.TrimSuffix(data.Bytes(), []byte("\n"))
+			}
+			return event, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if index := strings.IndexByte(line, ':'); index >= 0 {
+			field, value = line[:index], strings.TrimPrefix(line[index+1:], " ")
+		}
+
+		switch field {
+		case "data":
+			seen = true
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "event":
+			event.Event = value
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				event.ID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// JsonStream 换行分隔的json(NDJSON)迭代器
+type JsonStream[T any] struct {
+	ctx     context.Context
+	invoker *Invoker
+	idle    time.Duration
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	conn   *streamConn
+	closed bool
+	value  T
+	err    error
+}
+
+// NDJSON 以换行分隔json方式消费响应，连接在第一次 Next 时建立
+// 流的生命周期由ctx控制，invoker的 Timeout 不生效
+func NDJSON[T any](ctx context.Context, invoker *Invoker) *JsonStream[T] {
+	return &JsonStream[T]{
+		ctx:     ctx,
+		invoker: invoker.Copy(),
+	}
+}
+
+// IdleTimeout 超过d没有读到数据则结束
+func (stream *JsonStream[T]) IdleTimeout(d time.Duration) *JsonStream[T] {
+	stream.idle = d
+	return stream
+}
+
+// Next 读取下一个值，返回false时通过 Err 查看原因
+func (stream *JsonStream[T]) Next() bool {
+	stream.mutex.Lock()
+	if stream.closed || stream.err != nil {
+		stream.mutex.Unlock()
+		return false
+	}
+	conn := stream.conn
+	if conn == nil {
+		// 建立连接时不持有锁，Close 通过cancel打断
+		ctx, cancel := context.WithCancel(stream.ctx)
+		stream.cancel = cancel
+		stream.mutex.Unlock()
+
+		var err error
+		conn, _, err = openStream(ctx, stream.invoker, stream.idle, map[string]string{
+			"Accept": "application/x-ndjson",
+		})
+
+		stream.mutex.Lock()
+		if stream.closed {
+			stream.mutex.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return false
+		}
+		if err != nil {
+			stream.err = err
+			stream.mutex.Unlock()
+			return false
+		}
+		stream.conn = conn
+	}
+	stream.mutex.Unlock()
+
+	for {
+		line, err := conn.reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var value T
+			if jsonErr := json.Unmarshal(line, &value); jsonErr != nil {
+				stream.err = &InvokeError{Action: "ParseStream", Err: jsonErr}
+				return false
+			}
+			stream.value = value
+			return true
+		}
+
+		if err != nil {
+			if err != io.EOF && err != ErrStreamClosed {
+				stream.err = err
+			}
+			return false
+		}
+	}
+}
+
+// Value 当前值
+func (stream *JsonStream[T]) Value() T {
+	return stream.value
+}
+
+// Err 迭代结束的原因，正常结束或主动关闭时为nil
+func (stream *JsonStream[T]) Err() error {
+	return stream.err
+}
+
+// Close 关闭流，可以在其他goroutine中调用以打断 Next
+func (stream *JsonStream[T]) Close() error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.closed = true
+	if stream.cancel != nil {
+		stream.cancel()
+	}
+	if stream.conn != nil {
+		return stream.conn.Close()
+	}
+	return nil
+}
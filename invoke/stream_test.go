@@ -0,0 +1,139 @@
+package invoke
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEReconnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		fmt.Sscanf(r.Header.Get("Last-Event-ID"), "%d", &start)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, ": comment\nretry: 10\n\n")
+		// 每个连接只发送两个事件，之后断开
+		for id := start + 1; id <= start+2; id++ {
+			fmt.Fprintf(w, "id: %d\nevent: tick\ndata: line1\ndata: %d\n\n", id, id)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream := Addr(strings.TrimPrefix(server.URL, "http://")).
+		Get("/events").
+		Timeout(time.Millisecond).
+		SSE(ctx).
+		IdleTimeout(time.Second)
+	defer stream.Close()
+
+	for i := 1; i <= 5; i++ {
+		if !stream.Next() {
+			t.Fatal(stream.Err())
+		}
+		event := stream.Event()
+		if event.ID != fmt.Sprint(i) || event.Event != "tick" || string(event.Data) != fmt.Sprintf("line1\n%d", i) {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"id\":1}\n\n{\"id\":2}\n{\"id\":3}"))
+	}))
+	defer server.Close()
+
+	type item struct {
+		ID int `json:"id"`
+	}
+
+	stream := NDJSON[item](context.Background(), Addr(strings.TrimPrefix(server.URL, "http://")).Get("/"))
+	defer stream.Close()
+
+	var ids []int
+	for stream.Next() {
+		ids = append(ids, stream.Value().ID)
+	}
+	if stream.Err() != nil || fmt.Sprint(ids) != "[1 2 3]" {
+		t.Fatal(ids, stream.Err())
+	}
+}
+
+func TestNDJSONSkipsCache(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("{\"id\":1}\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	type item struct {
+		ID int `json:"id"`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	invoker := Addr(strings.TrimPrefix(server.URL, "http://")).Get("/").Cache(NewCache(NewMemoryCacheStore(16)))
+	stream := NDJSON[item](ctx, invoker)
+	defer stream.Close()
+
+	// 经过缓存时会等待读取完整的响应体
+	if !stream.Next() || stream.Value().ID != 1 {
+		t.Fatalf("stream should not be buffered by cache, err=%v", stream.Err())
+	}
+}
+
+func TestNDJSONCloseWhileConnecting(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	stream := NDJSON[int](context.Background(), Addr(strings.TrimPrefix(server.URL, "http://")).Get("/"))
+
+	done := make(chan bool)
+	go func() {
+		done <- stream.Next()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		stream.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close should not wait for connecting")
+	}
+	select {
+	case ok := <-done:
+		if ok || stream.Err() != nil {
+			t.Fatalf("closed stream should end without error, err=%v", stream.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close should interrupt connecting")
+	}
+}
@@ -0,0 +1,44 @@
+package invoke
+
+import (
+	"context"
+	"net/http"
+)
+
+// Template 不可变的调用模板，创建后配置不能再修改，可以在多个goroutine间共享
+// 每次调用通过 Call 得到独立的 Invoker，在其上设置本次调用的路径,参数等
+//
+//	orders := invoke.Addr(addr).Header("X-Api-Key", key).Timeout(time.Second).Template()
+//	rsp, err := orders.Call(ctx).Get("/v1/orders/{orderId}").PathParam("orderId", id).Request()
+type Template struct {
+	invoker *Invoker
+}
+
+// NewTemplate 以configure配置的invoker创建模板
+func NewTemplate(configure func(invoker *Invoker)) *Template {
+	invoker := New()
+	configure(invoker)
+	return invoker.Template()
+}
+
+// Template 以invoker当前的配置创建模板，之后对invoker的修改不影响模板
+func (invoker *Invoker) Template() *Template {
+	return &Template{invoker: invoker.Copy()}
+}
+
+// Call 创建一次调用，返回的 Invoker 只属于调用方
+func (template *Template) Call(ctx context.Context) *Invoker {
+	return template.invoker.Copy().Context(ctx)
+}
+
+// With 在模板的基础上派生新的模板，原模板不变
+func (template *Template) With(configure func(invoker *Invoker)) *Template {
+	invoker := template.invoker.Copy()
+	configure(invoker)
+	return &Template{invoker: invoker}
+}
+
+// Request 直接按模板的配置发起请求
+func (template *Template) Request(ctx context.Context) (*http.Response, error) {
+	return template.Call(ctx).Request()
+}
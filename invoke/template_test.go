@@ -0,0 +1,80 @@
+package invoke
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTemplateConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Api-Key")))
+	}))
+	defer server.Close()
+
+	var logged int64
+	template := Addr(strings.TrimPrefix(server.URL, "http://")).
+		Header("X-Api-Key", "key").
+		Timeout(time.Second).
+		OnlyStatus200().
+		Logger(func(time.Duration, *http.Request, *http.Response, error) {
+			atomic.AddInt64(&logged, 1)
+		}).
+		Template()
+
+	// 共享的invoker同样可以并发调用 Request
+	shared := template.Call(context.Background()).Get("/shared")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			rsp, err := template.Call(context.Background()).Get("/orders/{id}").PathParam("id", i).Request()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer rsp.Body.Close()
+
+			b, _ := ioutil.ReadAll(rsp.Body)
+			if string(b) != fmt.Sprintf("/orders/%d key", i) {
+				t.Errorf("unexpected body %q", b)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			rsp, err := shared.Request()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rsp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&logged); n != 32 {
+		t.Fatalf("custom logger not kept, logged %d", n)
+	}
+	if shared.ctx != context.Background() || shared.client != nil || shared.scheme != "" {
+		t.Fatal("Request mutated the invoker")
+	}
+}
+
+func TestCopyKeepsCallState(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "v")
+
+	invoker := New().Context(ctx).UserData("data").Logger(nil).Copy()
+	if invoker.ctx != ctx || invoker.userData != "data" || invoker.logger != nil {
+		t.Fatal("Copy dropped context, user data or logger")
+	}
+}
@@ -0,0 +1,228 @@
+package invoke
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrPinMismatch 服务端证书链中没有与固定的公钥匹配的证书
+var ErrPinMismatch = errors.New("invoke: no certificate matches pinned public keys")
+
+// TLSConfig https客户端的证书配置，构建的 http.Client 在第一次使用时创建并复用连接池
+//
+//	tlsConfig := invoke.NewTLSConfig().CAFile("/etc/ssl/internal-ca.pem").ClientCert("client.pem", "client.key")
+//	invoker.TLSConfig(tlsConfig)
+type TLSConfig struct {
+	caFiles        []string
+	certFile       string
+	keyFile        string
+	reloadInterval time.Duration
+	minVersion     uint16
+	serverName     string
+	pins           map[string]bool
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+// NewTLSConfig 默认最低TLS1.2
+func NewTLSConfig() *TLSConfig {
+	return &TLSConfig{
+		reloadInterval: 10 * time.Second,
+		minVersion:     tls.VersionTLS12,
+	}
+}
+
+// CAFile 只信任这些PEM文件中的CA
+func (config *TLSConfig) CAFile(paths ...string) *TLSConfig {
+	config.caFiles = append(config.caFiles, paths...)
+	return config
+}
+
+// ClientCert 双向TLS的客户端证书与私钥，文件修改后自动重新加载
+func (config *TLSConfig) ClientCert(certFile, keyFile string) *TLSConfig {
+	config.certFile = certFile
+	config.keyFile = keyFile
+	return config
+}
+
+// ReloadInterval 检查客户端证书文件修改的间隔，默认10秒
+func (config *TLSConfig) ReloadInterval(d time.Duration) *TLSConfig {
+	config.reloadInterval = d
+	return config
+}
+
+// MinVersion 最低TLS版本，例如 tls.VersionTLS13
+func (config *TLSConfig) MinVersion(version uint16) *TLSConfig {
+	config.minVersion = version
+	return config
+}
+
+// ServerName 覆盖SNI以及校验证书时使用的域名
+func (config *TLSConfig) ServerName(name string) *TLSConfig {
+	config.serverName = name
+	return config
+}
+
+// PinSPKI 固定公钥，pin为证书SubjectPublicKeyInfo的sha256的base64，证书链中任意一张匹配即可
+func (config *TLSConfig) PinSPKI(pins ...string) *TLSConfig {
+	if config.pins == nil {
+		config.pins = make(map[string]bool)
+	}
+	for _, pin := range pins {
+		config.pins[pin] = true
+	}
+	return config
+}
+
+// SPKIPin 计算证书的公钥固定值，用于 PinSPKI
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TLSConfig 使用证书配置并切换到https，通过 Client 指定了客户端时以 Client 为准
+func (invoker *Invoker) TLSConfig(config *TLSConfig) *Invoker {
+	invoker.tlsConfig = config
+	invoker.scheme = "https"
+	return invoker
+}
+
+// Build 创建 tls.Config，配置错误时返回 InvokeError
+func (config *TLSConfig) Build() (*tls.Config, error) {
+	switch config.minVersion {
+	case tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		return nil, &InvokeError{"TLSMinVersion", fmt.Errorf("unknown tls version 0x%04x", config.minVersion), false, false}
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: config.minVersion,
+		ServerName: config.serverName,
+	}
+
+	if len(config.caFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, path := range config.caFiles {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, &InvokeError{"LoadCA", err, false, false}
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, &InvokeError{"LoadCA", fmt.Errorf("no certificate found in %s", path), false, false}
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.certFile != "" || config.keyFile != "" {
+		reloader := &certReloader{certFile: config.certFile, keyFile: config.keyFile, interval: config.reloadInterval}
+		if err := reloader.load(); err != nil {
+			return nil, &InvokeError{"LoadClientCert", err, false, false}
+		}
+		tlsConfig.GetClientCertificate = reloader.get
+	}
+
+	if len(config.pins) > 0 {
+		pins := config.pins
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				if pins[SPKIPin(cert)] {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// httpClient 基于 DefaultHttpClient 的传输配置创建客户端，只创建一次
+func (config *TLSConfig) httpClient() (*http.Client, error) {
+	config.once.Do(func() {
+		tlsConfig, err := config.Build()
+		if err != nil {
+			config.err = err
+			return
+		}
+
+		transport := DefaultHttpClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		config.client = &http.Client{Transport: transport}
+	})
+	return config.client, config.err
+}
+
+// certReloader 文件修改后重新加载客户端证书，加载失败时继续使用旧证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (reloader *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{reloader.certFile, reloader.keyFile} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (reloader *certReloader) load() error {
+	modTime, err := reloader.modified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.cert = &cert
+	reloader.modTime = modTime
+	reloader.lastCheck = time.Now()
+	return nil
+}
+
+func (reloader *certReloader) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	if time.Since(reloader.lastCheck) < reloader.interval {
+		return reloader.cert, nil
+	}
+	reloader.lastCheck = time.Now()
+
+	modTime, err := reloader.modified()
+	if err == nil && !modTime.Equal(reloader.modTime) {
+		err = reloader.load()
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("cert", reloader.certFile).Warnln("ReloadClientCertFailed")
+	}
+	return reloader.cert, nil
+}
@@ -0,0 +1,183 @@
+package invoke
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeClientCert 生成自签名的客户端证书
+func writeClientCert(t *testing.T, dir, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDer)
+}
+
+func tlsServerCA(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, path, "CERTIFICATE", server.Certificate().Raw)
+	return path
+}
+
+func TestTLSConfigVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.ServerName))
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+	ca := tlsServerCA(t, server)
+
+	rsp, err := Addr(addr).Get("/").TLSConfig(NewTLSConfig().CAFile(ca).ServerName("example.com")).OnlyStatus200().Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if string(b) != "example.com" {
+		t.Fatalf("expect sni example.com, got %q", b)
+	}
+
+	_, err = Addr(addr).Get("/").TLS().Request()
+	if err == nil || err.(*InvokeError).Action != "DoRequest" {
+		t.Fatalf("expect untrusted certificate rejected, got %v", err)
+	}
+
+	_, err = Addr(addr).Get("/").TLSConfig(NewTLSConfig().CAFile(ca).ServerName("other.test")).Request()
+	if err == nil {
+		t.Fatal("expect hostname mismatch")
+	}
+
+	pin := SPKIPin(server.Certificate())
+	_, err = Addr(addr).Get("/").TLSConfig(NewTLSConfig().CAFile(ca).PinSPKI(pin)).OnlyStatus200().Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Addr(addr).Get("/").TLSConfig(NewTLSConfig().CAFile(ca).PinSPKI("AAAA")).Request()
+	if err == nil || err.(*InvokeError).Action != "VerifyPin" {
+		t.Fatalf("expect pin mismatch, got %v", err)
+	}
+}
+
+func TestTLSConfigMinVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+	ca := tlsServerCA(t, server)
+
+	if _, err := Addr(addr).Get("/").TLSConfig(NewTLSConfig().CAFile(ca)).OnlyStatus200().Request(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Addr(addr).Get("/").TLSConfig(NewTLSConfig().CAFile(ca).MinVersion(tls.VersionTLS13)).Request(); err == nil {
+		t.Fatal("expect handshake failure")
+	}
+}
+
+func TestTLSConfigClientCertReload(t *testing.T) {
+	dir := t.TempDir()
+	writeClientCert(t, dir, "first")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	config := NewTLSConfig().
+		CAFile(tlsServerCA(t, server)).
+		ClientCert(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")).
+		ReloadInterval(0)
+
+	call := func() string {
+		rsp, err := Addr(addr).Get("/").TLSConfig(config).OnlyStatus200().Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		b, _ := ioutil.ReadAll(rsp.Body)
+		return string(b)
+	}
+
+	if name := call(); name != "first" {
+		t.Fatalf("expect first, got %q", name)
+	}
+
+	writeClientCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "client.pem"), future, future)
+	server.CloseClientConnections()
+	config.client.CloseIdleConnections()
+
+	if name := call(); name != "second" {
+		t.Fatalf("expect reloaded certificate, got %q", name)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a certificate"), 0600)
+
+	cases := []struct {
+		action string
+		config *TLSConfig
+	}{
+		{"LoadCA", NewTLSConfig().CAFile(filepath.Join(dir, "missing.pem"))},
+		{"LoadCA", NewTLSConfig().CAFile(garbage)},
+		{"LoadClientCert", NewTLSConfig().ClientCert(garbage, garbage)},
+		{"TLSMinVersion", NewTLSConfig().MinVersion(0x0999)},
+	}
+
+	for _, c := range cases {
+		_, err := Addr("127.0.0.1:1").Get("/").TLSConfig(c.config).Request()
+		invokeErr, ok := err.(*InvokeError)
+		if !ok || invokeErr.Action != c.action {
+			t.Fatalf("expect %s, got %v", c.action, err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNoAvailableAddr = errors.New("no available address")
+)
+
+// Endpoint 地址集中的一个地址，记录该地址的负载与健康状态
+type Endpoint struct {
+	Addr string
+
+	weight       int64 // 权重，可以在运行时修改
+	pending      int64 // 正在进行的请求数
+	failures     int64 // 连续失败次数
+	ejectedUntil int64 // 被动摘除截止时间(UnixNano)
+	unhealthy    int32 // 主动探测失败标记
+}
+
+// Weight 返回该地址的权重
+func (ep *Endpoint) Weight() int {
+	return int(atomic.LoadInt64(&ep.weight))
+}
+
+// Pending 返回该地址正在进行中的请求数
+func (ep *Endpoint) Pending() int64 {
+	return atomic.LoadInt64(&ep.pending)
+}
+
+// Available 地址是否可用，被摘除或者探测失败的地址不可用
+func (ep *Endpoint) Available(now time.Time) bool {
+	if atomic.LoadInt32(&ep.unhealthy) != 0 {
+		return false
+	}
+	return atomic.LoadInt64(&ep.ejectedUntil) <= now.UnixNano()
+}
+
+// Balancer 负载均衡器，从候选地址中挑选一个，key为请求指定的均衡键
+// candidates 不会为空
+type Balancer interface {
+	Pick(candidates []*Endpoint, key string) *Endpoint
+}
+
+// Upstream 同一个服务的多个地址，负责地址选择，被动摘除与主动探测
+type Upstream struct {
+	mutex     sync.RWMutex
+	endpoints []*Endpoint
+	balancer  Balancer
+
+	maxFailures   int64
+	ejectDuration time.Duration
+
+	stopProbe func()
+}
+
+// NewUpstream 使用一组地址创建地址集，默认轮询
+func NewUpstream(addrs ...string) *Upstream {
+	upstream := &Upstream{
+		balancer:      RoundRobin(),
+		maxFailures:   5,
+		ejectDuration: 30 * time.Second,
+	}
+	upstream.SetAddrs(addrs...)
+	return upstream
+}
+
+// Balancer 指定负载均衡器
+func (upstream *Upstream) Balancer(balancer Balancer) *Upstream {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	upstream.balancer = balancer
+	return upstream
+}
+
+// Outlier 连续失败maxFailures次后，摘除该地址ejectFor时间，maxFailures<=0则关闭摘除
+func (upstream *Upstream) Outlier(maxFailures int, ejectFor time.Duration) *Upstream {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	upstream.maxFailures = int64(maxFailures)
+	upstream.ejectDuration = ejectFor
+	return upstream
+}
+
+// Weight 设置地址的权重，配合 Weighted 使用，可以在请求进行中修改
+func (upstream *Upstream) Weight(addr string, weight int) *Upstream {
+	upstream.mutex.RLock()
+	defer upstream.mutex.RUnlock()
+
+	for _, ep := range upstream.endpoints {
+		if ep.Addr == addr {
+			atomic.StoreInt64(&ep.weight, int64(weight))
+		}
+	}
+	return upstream
+}
+
+// SetAddrs 替换地址集，已存在的地址保留其状态
+func (upstream *Upstream) SetAddrs(addrs ...string) {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	old := make(map[string]*Endpoint, len(upstream.endpoints))
+	for _, ep := range upstream.endpoints {
+		old[ep.Addr] = ep
+	}
+
+	endpoints := make([]*Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		if ep, exist := old[addr]; exist {
+			endpoints = append(endpoints, ep)
+			continue
+		}
+		endpoints = append(endpoints, &Endpoint{Addr: addr, weight: 1})
+	}
+
+	upstream.endpoints = endpoints
+}
+
+// Endpoints 返回当前的地址列表
+func (upstream *Upstream) Endpoints() []*Endpoint {
+	upstream.mutex.RLock()
+	defer upstream.mutex.RUnlock()
+
+	endpoints := make([]*Endpoint, len(upstream.endpoints))
+	copy(endpoints, upstream.endpoints)
+	return endpoints
+}
+
+// HealthCheck 每隔interval对所有地址执行一次probe，探测失败的地址不参与选择，直到探测成功
+// 重复调用会停止之前的探测
+func (upstream *Upstream) HealthCheck(interval time.Duration, probe func(ctx context.Context, addr string) error) *Upstream {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	upstream.mutex.Lock()
+	if upstream.stopProbe != nil {
+		upstream.stopProbe()
+	}
+	upstream.stopProbe = cancel
+	upstream.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			upstream.probeAll(ctx, interval, probe)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return upstream
+}
+
+func (upstream *Upstream) probeAll(ctx context.Context, timeout time.Duration, probe func(ctx context.Context, addr string) error) {
+	var wg sync.WaitGroup
+	for _, ep := range upstream.Endpoints() {
+		wg.Add(1)
+		go func(ep *Endpoint) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if err := probe(probeCtx, ep.Addr); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if atomic.SwapInt32(&ep.unhealthy, 1) == 0 {
+					logrus.WithError(err).WithField("addr", ep.Addr).Warnln("UpstreamProbeFailed")
+				}
+				return
+			}
+
+			if atomic.SwapInt32(&ep.unhealthy, 0) != 0 {
+				logrus.WithField("addr", ep.Addr).Infoln("UpstreamProbeRecovered")
+			}
+		}(ep)
+	}
+	wg.Wait()
+}
+
+// Close 停止主动探测
+func (upstream *Upstream) Close() {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	if upstream.stopProbe != nil {
+		upstream.stopProbe()
+		upstream.stopProbe = nil
+	}
+}
+
+// pick 挑选一个地址，尽量避开exclude中已经尝试过的地址
+// 当所有地址都不可用时，忽略可用状态在全部地址中挑选
+func (upstream *Upstream) pick(key string, exclude []*Endpoint) *Endpoint {
+	upstream.mutex.RLock()
+	endpoints := upstream.endpoints
+	balancer := upstream.balancer
+	upstream.mutex.RUnlock()
+
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	candidates := make([]*Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.Available(now) && !containsEndpoint(exclude, ep) {
+			candidates = append(candidates, ep)
+		}
+	}
+
+	if len(candidates) == 0 {
+		for _, ep := range endpoints {
+			if ep.Available(now) {
+				candidates = append(candidates, ep)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = endpoints
+	}
+
+	return balancer.Pick(candidates, key)
+}
+
+// report 记录一次请求结果，用于被动摘除
+func (upstream *Upstream) report(ep *Endpoint, failed bool) {
+	if !failed {
+		atomic.StoreInt64(&ep.failures, 0)
+		return
+	}
+
+	upstream.mutex.RLock()
+	maxFailures := upstream.maxFailures
+	ejectDuration := upstream.ejectDuration
+	upstream.mutex.RUnlock()
+
+	failures := atomic.AddInt64(&ep.failures, 1)
+	if maxFailures <= 0 || failures < maxFailures {
+		return
+	}
+
+	atomic.StoreInt64(&ep.failures, 0)
+	atomic.StoreInt64(&ep.ejectedUntil, time.Now().Add(ejectDuration).UnixNano())
+	logrus.WithFields(logrus.Fields{
+		"addr":     ep.Addr,
+		"failures": failures,
+		"ejectFor": ejectDuration,
+	}).Warnln("UpstreamEjected")
+}
+
+func containsEndpoint(list []*Endpoint, ep *Endpoint) bool {
+	for _, item := range list {
+		if item == ep {
+			return true
+		}
+	}
+	return false
+}
+
+// HttpProbe 返回一个HTTP探测函数，请求 scheme://addr/path，状态码非2xx视为失败
+func HttpProbe(scheme, path string) func(ctx context.Context, addr string) error {
+	return func(ctx context.Context, addr string) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", scheme+"://"+addr+path, nil)
+		if err != nil {
+			return err
+		}
+
+		rsp, err := DefaultHttpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		rsp.Body.Close()
+
+		if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+			return fmt.Errorf("status=%d", rsp.StatusCode)
+		}
+		return nil
+	}
+}
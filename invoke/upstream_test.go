@@ -0,0 +1,120 @@
+package invoke
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamRetryAndEject(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+
+	badAddr := strings.TrimPrefix(bad.URL, "http://")
+	goodAddr := strings.TrimPrefix(good.URL, "http://")
+
+	upstream := NewUpstream(badAddr, goodAddr).Outlier(1, time.Minute)
+	for i := 0; i < 4; i++ {
+		rsp, err := New().Upstream(upstream).Retry(1).Get("/").AutoCloseResponseBody().Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsp.StatusCode != 200 {
+			t.Fatalf("attempt %d status=%d", i, rsp.StatusCode)
+		}
+		rsp.Body.Close()
+	}
+
+	for _, ep := range upstream.Endpoints() {
+		if ep.Addr == badAddr && ep.Available(time.Now()) {
+			t.Fatal("bad address should be ejected")
+		}
+	}
+}
+
+func TestRetryMethod(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	cases := []struct {
+		invoker *Invoker
+		hits    int64
+	}{
+		{Addr(addr).Get("/"), 3},
+		{Addr(addr).Post("/"), 1},
+		{Addr(addr).Post("/").RetryAnyMethod(), 3},
+	}
+	for _, c := range cases {
+		atomic.StoreInt64(&hits, 0)
+		if _, err := c.invoker.Retry(2).AutoCloseResponseBody().Request(); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt64(&hits); n != c.hits {
+			t.Fatalf("%s expect %d attempts, got %d", c.invoker.method, c.hits, n)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	endpoints := []*Endpoint{{Addr: "a:1"}, {Addr: "b:1"}, {Addr: "c:1"}}
+	balancer := ConsistentHash()
+
+	picked := balancer.Pick(endpoints, "BTC_USDT")
+	for i := 0; i < 10; i++ {
+		if balancer.Pick(endpoints, "BTC_USDT") != picked {
+			t.Fatal("same key should pick same address")
+		}
+	}
+
+	var rest []*Endpoint
+	for _, ep := range endpoints {
+		if ep != picked {
+			rest = append(rest, ep)
+		}
+	}
+	rest = append(rest, &Endpoint{Addr: "d:1"})
+	other := balancer.Pick(append(rest, picked), "BTC_USDT")
+	if other != picked && other.Addr != "d:1" {
+		t.Fatal("key should only move to the new address")
+	}
+}
+
+func TestWeightedConcurrentUpdate(t *testing.T) {
+	upstream := NewUpstream("a:1", "b:1").Balancer(Weighted()).Weight("a:1", 3)
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[upstream.pick("", nil).Addr]++
+	}
+	if counts["a:1"] != 6 || counts["b:1"] != 2 {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+
+	// 选择进行中修改权重，配合 -race 检查
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			upstream.Weight("b:1", i%5)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		upstream.pick("", nil)
+	}
+	<-done
+}
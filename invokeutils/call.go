@@ -0,0 +1,110 @@
+package invokeutils
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hello-pionex/mystic-go/code"
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+// Envelope 将响应体解析到out中，返回业务错误
+type Envelope interface {
+	Extract(body []byte, out interface{}) code.Error
+}
+
+// EnvelopeFunc 函数形式的 Envelope
+type EnvelopeFunc func(body []byte, out interface{}) code.Error
+
+func (fn EnvelopeFunc) Extract(body []byte, out interface{}) code.Error {
+	return fn(body, out)
+}
+
+var (
+	// StandardEnvelope 即：{result:true,mcode:"<code>",data:{}}
+	StandardEnvelope Envelope = EnvelopeFunc(func(body []byte, out interface{}) code.Error {
+		if len(body) == 0 {
+			return code.NewMcode(MCODE_INVOKE_MALFORMED, "return json body empty")
+		}
+
+		var res Response
+		if err := json.Unmarshal(body, &res); err != nil {
+			return code.NewMcode(MCODE_INVOKE_MALFORMED, "return json body error")
+		}
+
+		return ExtractHeader("", nil, http.StatusOK, &res, out)
+	})
+
+	// RawEnvelope 无封装的json
+	RawEnvelope Envelope = EnvelopeFunc(func(body []byte, out interface{}) code.Error {
+		if err := json.Unmarshal(body, out); err != nil {
+			return code.NewMcode(MCODE_INVOKE_MALFORMED, "parse return json payload failed")
+		}
+		return nil
+	})
+)
+
+// Call 执行请求并按 StandardEnvelope 解析结果
+func Call[T any](ctx context.Context, invoker *invoke.Invoker) (T, code.Error) {
+	return CallWith[T](ctx, invoker, StandardEnvelope)
+}
+
+// CallRaw 执行请求并将响应体直接作为json解析
+func CallRaw[T any](ctx context.Context, invoker *invoke.Invoker) (T, code.Error) {
+	return CallWith[T](ctx, invoker, RawEnvelope)
+}
+
+// CallWith 执行请求并使用指定的 Envelope 解析结果，响应体总会被关闭
+// invoker 不会被修改，作为模板时不要设置 AutoCloseResponseBody，否则响应体在解析前已被关闭
+// 返回的错误为 *UpstreamError，包含服务名，HTTP状态，上游的mcode以及截断后的响应体
+func CallWith[T any](ctx context.Context, invoker *invoke.Invoker, envelope Envelope) (T, code.Error) {
+	var out T
+	service := invoker.ServiceName()
+
+	rsp, err := invoker.Copy().Context(ctx).Request()
+	if rsp != nil && rsp.Body != nil {
+		defer rsp.Body.Close()
+	}
+
+	if err != nil {
+		if codeErr, ok := err.(code.Error); ok {
+			return out, codeErr
+		}
+
+		// 状态错误时仍然按响应处理，响应体可能已被 OnlyStatus200 关闭，读取失败时不保留响应体
+		if rsp == nil || rsp.StatusCode == http.StatusOK {
+			return out, ExtractHeader(service, err, 0, nil, nil)
+		}
+	}
+
+	return out, extractResponse(service, rsp, envelope, &out)
+}
+
+// extractResponse 读取响应体并按envelope解析，状态码，长度限制以及错误中保留的响应体统一在这里处理
+func extractResponse(service string, rsp *http.Response, envelope Envelope, out interface{}) code.Error {
+	var (
+		body []byte
+		err  error
+	)
+	if rsp.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(rsp.Body, MaxResponseBodySize+1))
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return statusError(service, rsp.StatusCode, body)
+	}
+	if err != nil {
+		return withResponse(ExtractHeader(service, err, 0, nil, nil), service, rsp.StatusCode, body)
+	}
+	if int64(len(body)) > MaxResponseBodySize {
+		return withResponse(ExtractHeader(service, ErrBodyTooLarge, 0, nil, nil), service, rsp.StatusCode, body)
+	}
+
+	if codeErr := envelope.Extract(body, out); codeErr != nil {
+		return withResponse(codeErr, service, rsp.StatusCode, body)
+	}
+	return nil
+}
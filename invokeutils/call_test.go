@@ -0,0 +1,166 @@
+package invokeutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hello-pionex/mystic-go/code"
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+// closeCounter 统计响应体的打开与关闭次数
+type closeCounter struct {
+	opened int64
+	closed int64
+}
+
+func (counter *closeCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	rsp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&counter.opened, 1)
+	rsp.Body = &countedBody{ReadCloser: rsp.Body, counter: counter}
+	return rsp, nil
+}
+
+type countedBody struct {
+	io.ReadCloser
+	counter *closeCounter
+	once    int32
+}
+
+func (body *countedBody) Close() error {
+	if atomic.CompareAndSwapInt32(&body.once, 0, 1) {
+		atomic.AddInt64(&body.counter.closed, 1)
+	}
+	return body.ReadCloser.Close()
+}
+
+type order struct {
+	ID    string `json:"id"`
+	Price int    `json:"price"`
+}
+
+func TestCall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"result":true,"data":{"id":"o1","price":10}}`))
+		case "/fail":
+			w.Write([]byte(`{"result":false,"mcode":"NO_BALANCE","message":"no money"}`))
+		case "/raw":
+			w.Write([]byte(`{"id":"o2","price":20}`))
+		case "/custom":
+			w.Write([]byte(`{"code":0,"payload":{"id":"o3","price":30}}`))
+		case "/custom-fail":
+			w.Write([]byte(`{"code":1001,"payload":null}`))
+		case "/status":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"result":false,"mcode":"DB_DOWN"}`))
+		case "/malformed":
+			w.Write([]byte(`not json`))
+		}
+	}))
+	defer server.Close()
+
+	counter := &closeCounter{}
+	client := &http.Client{Transport: counter}
+	addr := strings.TrimPrefix(server.URL, "http://")
+	ctx := context.Background()
+
+	value, err := Call[order](ctx, invoke.Addr(addr).Get("/ok").Client(client))
+	if err != nil || value.ID != "o1" || value.Price != 10 {
+		t.Fatalf("unexpected result %+v %v", value, err)
+	}
+
+	_, err = Call[order](ctx, invoke.Addr(addr).Get("/fail").Client(client))
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) || err.Mcode() != "NO_BALANCE" || err.Message() != "no money" || upstreamErr.UpstreamMcode != "NO_BALANCE" {
+		t.Fatalf("upstream mcode should pass through, got %v", err)
+	}
+
+	_, err = Call[order](ctx, invoke.Addr(addr).Get("/status").Client(client))
+	if err == nil || err.Mcode() != MCODE_INVOKE_FAILED || !errors.As(err, &upstreamErr) || upstreamErr.UpstreamMcode != "DB_DOWN" {
+		t.Fatalf("unexpected status error %v", err)
+	}
+
+	_, err = Call[order](ctx, invoke.Addr(addr).Get("/malformed").Client(client))
+	if err == nil || err.Mcode() != MCODE_INVOKE_MALFORMED {
+		t.Fatalf("unexpected malformed error %v", err)
+	}
+
+	value, err = CallRaw[order](ctx, invoke.Addr(addr).Get("/raw").Client(client))
+	if err != nil || value.ID != "o2" || value.Price != 20 {
+		t.Fatalf("unexpected raw result %+v %v", value, err)
+	}
+
+	envelope := EnvelopeFunc(func(body []byte, out interface{}) code.Error {
+		var res struct {
+			Code    int             `json:"code"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return code.NewMcode(MCODE_INVOKE_MALFORMED, err.Error())
+		}
+		if res.Code != 0 {
+			return code.NewMcodef("UPSTREAM_ERROR", "code=%d", res.Code)
+		}
+		if err := json.Unmarshal(res.Payload, out); err != nil {
+			return code.NewMcode(MCODE_INVOKE_MALFORMED, err.Error())
+		}
+		return nil
+	})
+
+	value, err = CallWith[order](ctx, invoke.Addr(addr).Get("/custom").Client(client), envelope)
+	if err != nil || value.ID != "o3" || value.Price != 30 {
+		t.Fatalf("unexpected custom result %+v %v", value, err)
+	}
+
+	_, err = CallWith[order](ctx, invoke.Addr(addr).Get("/custom-fail").Client(client), envelope)
+	if err == nil || err.Mcode() != "UPSTREAM_ERROR" || err.Message() != "code=1001" {
+		t.Fatalf("unexpected custom error %v", err)
+	}
+	if !errors.As(err, &upstreamErr) || upstreamErr.Status != http.StatusOK || !strings.Contains(upstreamErr.Body, "1001") {
+		t.Fatalf("custom envelope error should keep the response, got %+v", upstreamErr)
+	}
+
+	if opened, closed := atomic.LoadInt64(&counter.opened), atomic.LoadInt64(&counter.closed); opened != 7 || closed != opened {
+		t.Fatalf("response bodies not closed, opened=%d closed=%d", opened, closed)
+	}
+}
+
+func TestCallTransportError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, codeErr := Call[order](context.Background(), invoke.Addr(addr).Get("/"))
+	if codeErr == nil || codeErr.Mcode() != MCODE_INVOKE_CONN_REFUSED {
+		t.Fatalf("unexpected error %v", codeErr)
+	}
+
+	var upstreamErr *UpstreamError
+	var transportErr *TransportError
+	var opErr *net.OpError
+	if !errors.As(codeErr, &upstreamErr) || upstreamErr.Status != 0 {
+		t.Fatalf("expect UpstreamError without status, got %v", codeErr)
+	}
+	if !errors.As(codeErr, &transportErr) || !transportErr.Retryable() {
+		t.Fatalf("expect retryable TransportError, got %v", codeErr)
+	}
+	if !errors.As(codeErr, &opErr) {
+		t.Fatalf("original error should be kept, got %v", codeErr)
+	}
+}
@@ -0,0 +1,188 @@
+package invokeutils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"syscall"
+
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+// MaxResponseBodySize 解析响应时读取的最大长度，超出时返回 MCODE_INVOKE_TOO_LARGE
+var MaxResponseBodySize int64 = 32 << 20
+
+// ErrBodyTooLarge 响应体超出 MaxResponseBodySize
+var ErrBodyTooLarge = errors.New("invokeutils: response body too large")
+
+// TransportError 分类后的调用错误，实现 code.Error，可以通过 errors.As 取出原始错误
+type TransportError struct {
+	mcode     string
+	message   string
+	retryable bool
+	timeout   bool
+	err       error
+}
+
+func (err *TransportError) Error() string {
+	return fmt.Sprintf("%s,%s", err.mcode, err.message)
+}
+
+func (err *TransportError) Mcode() string {
+	return err.mcode
+}
+
+func (err *TransportError) Message() string {
+	return err.message
+}
+
+// Retryable 重试是否可能成功，连接重置时请求可能已被处理，非幂等的请求需要自行判断
+func (err *TransportError) Retryable() bool {
+	return err.retryable
+}
+
+func (err *TransportError) Timeout() bool {
+	return err.timeout
+}
+
+func (err *TransportError) Unwrap() error {
+	return err.err
+}
+
+func classified(err error, mcode string, retryable, timeout bool, format string, args ...interface{}) *TransportError {
+	return &TransportError{
+		mcode:     mcode,
+		message:   fmt.Sprintf(format, args...),
+		retryable: retryable,
+		timeout:   timeout,
+		err:       err,
+	}
+}
+
+// Classify 按错误链识别调用失败的原因，包括被 invoke.InvokeError 与 url.Error 包装的错误
+// 优先按错误类型与哨兵错误识别，net/http 没有导出类型的少数错误按消息前缀识别，见 lastResort
+func Classify(err error) *TransportError {
+	if err == nil {
+		return nil
+	}
+
+	var classifiedErr *TransportError
+	if errors.As(err, &classifiedErr) {
+		return classifiedErr
+	}
+
+	var (
+		dnsErr       *net.DNSError
+		opErr        *net.OpError
+		netErr       net.Error
+		unknownCA    x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidCert  x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+		syntaxErr    *json.SyntaxError
+		typeErr      *json.UnmarshalTypeError
+		protocolErr  textproto.ProtocolError
+		invokeErr    *invoke.InvokeError
+		addr         string
+		invokeAction string
+	)
+	isDNS := errors.As(err, &dnsErr)
+	isOp := errors.As(err, &opErr)
+	if isOp && opErr.Addr != nil {
+		addr = opErr.Addr.String()
+	}
+	if errors.As(err, &invokeErr) {
+		invokeAction = invokeErr.Action
+	}
+	innermost := unwrapAll(err)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return classified(err, MCODE_INVOKE_CANCELED, false, false, "invoke canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return classified(err, MCODE_INVOKE_TIMEOUT, true, true, "deadline exceeded")
+	case isDNS && dnsErr.IsNotFound:
+		return classified(err, MCODE_INVOKE_DNS_NOT_FOUND, false, false, "host not found,host=%s", dnsErr.Name)
+	case isDNS:
+		return classified(err, MCODE_INVOKE_DNS_FAILED, true, dnsErr.IsTimeout, "dns lookup failed,host=%s", dnsErr.Name)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return classified(err, MCODE_INVOKE_CONN_REFUSED, true, false, "connection refused,addr=%s", addr)
+	case errors.Is(err, invoke.ErrPinMismatch),
+		errors.As(err, &unknownCA),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidCert),
+		certificateRejected(err):
+		return classified(err, MCODE_INVOKE_TLS_VERIFY, false, false, "tls verification failed,%v", innermost)
+	case invokeAction == "LoadCA", invokeAction == "LoadClientCert", invokeAction == "TLSMinVersion":
+		return classified(err, MCODE_INVOKE_TLS_HANDSHAKE, false, false, "invalid tls config,%v", err)
+	case errors.As(err, &recordErr),
+		schemeMismatch(err),
+		isOp && tlsAlert(opErr):
+		return classified(err, MCODE_INVOKE_TLS_HANDSHAKE, false, false, "tls handshake failed,%v", innermost)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return classified(err, MCODE_INVOKE_TIMEOUT, true, true, "network timeout,addr=%s", addr)
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return classified(err, MCODE_INVOKE_CONN_RESET, true, false, "connection reset,addr=%s", addr)
+	case errors.Is(err, ErrBodyTooLarge), maxBytesExceeded(err):
+		return classified(err, MCODE_INVOKE_TOO_LARGE, false, false, "%v", innermost)
+	case errors.As(err, &syntaxErr),
+		errors.As(err, &typeErr),
+		errors.As(err, &protocolErr),
+		invokeAction == "DecompressResponse":
+		return classified(err, MCODE_INVOKE_MALFORMED, false, false, "malformed response,%v", innermost)
+	case errors.Is(err, http.ErrBodyReadAfterClose):
+		return classified(err, MCODE_INVOKE_FAILED, false, false, "response body already closed")
+	}
+
+	if mcode, ok := lastResort(innermost); ok {
+		return classified(err, mcode, false, false, "%v", innermost)
+	}
+	if invokeErr != nil {
+		return classified(err, MCODE_INVOKE_FAILED, invokeErr.IsTemporary, invokeErr.IsTimeout, "invoke error,%v", err)
+	}
+	return classified(err, MCODE_INVOKE_FAILED, false, false, "%v", err)
+}
+
+// tlsAlert crypto/tls 收到或发出的TLS告警，以 net.OpError 的 remote error 与 local error 返回
+func tlsAlert(opErr *net.OpError) bool {
+	return opErr.Op == "remote error" || opErr.Op == "local error"
+}
+
+// lastResort 按 net/http 与 crypto/tls 的消息前缀识别没有导出类型的错误，只匹配最内层的错误，
+// 消息格式变化时退化为 MCODE_INVOKE_FAILED
+func lastResort(innermost error) (string, bool) {
+	message := innermost.Error()
+	switch {
+	case strings.HasPrefix(message, "tls: "):
+		// 握手时本端拒绝对端的参数，例如不支持的协议版本
+		return MCODE_INVOKE_TLS_HANDSHAKE, true
+	case strings.HasPrefix(message, "net/http: server response headers exceeded"):
+		return MCODE_INVOKE_TOO_LARGE, true
+	case strings.HasPrefix(message, "malformed HTTP"):
+		// 状态行或版本无法解析
+		return MCODE_INVOKE_MALFORMED, true
+	}
+	return "", false
+}
+
+// unwrapAll 最内层的错误，去掉重复的url与动作前缀
+func unwrapAll(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !go1.21
+
+package invokeutils
+
+import (
+	"strings"
+)
+
+// certificateRejected go1.20之前没有 tls.CertificateVerificationError，由x509的错误类型识别
+func certificateRejected(err error) bool {
+	return false
+}
+
+// schemeMismatch go1.21之前没有导出 http.ErrSchemeMismatch，按消息识别
+func schemeMismatch(err error) bool {
+	return strings.HasSuffix(unwrapAll(err).Error(), "server gave HTTP response to HTTPS client")
+}
+
+// maxBytesExceeded go1.19之前没有 http.MaxBytesError
+func maxBytesExceeded(err error) bool {
+	return false
+}
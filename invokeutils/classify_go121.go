@@ -0,0 +1,25 @@
+//go:build go1.21
+
+package invokeutils
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// certificateRejected 证书校验失败，包括自定义校验返回的错误
+func certificateRejected(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	return errors.As(err, &verifyErr)
+}
+
+// schemeMismatch 使用TLS连接了HTTP服务
+func schemeMismatch(err error) bool {
+	return errors.Is(err, http.ErrSchemeMismatch)
+}
+
+func maxBytesExceeded(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
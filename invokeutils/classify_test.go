@@ -0,0 +1,134 @@
+package invokeutils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+// dnsClient 使用固定解析结果的http客户端
+func dnsClient(err error) *http.Client {
+	cache := invoke.NewDNSCache().Lookup(func(ctx context.Context, host string) ([]string, error) {
+		return nil, err
+	})
+	return &http.Client{Transport: &http.Transport{DialContext: cache.DialContext(&net.Dialer{})}}
+}
+
+func resetHandler(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+}
+
+func TestClassify(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/reset", resetHandler)
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":true,"data":"` + strings.Repeat("x", 128) + `"}`))
+	})
+	mux.HandleFunc("/malformed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":true,"data":{`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	tlsServer := httptest.NewTLSServer(mux)
+	defer tlsServer.Close()
+	tlsAddr := strings.TrimPrefix(tlsServer.URL, "https://")
+
+	tls12Server := httptest.NewUnstartedServer(mux)
+	tls12Server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	tls12Server.StartTLS()
+	defer tls12Server.Close()
+	tls13Client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS13, InsecureSkipVerify: true}}}
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := listener.Addr().String()
+	listener.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	defer func(size int64) { MaxResponseBodySize = size }(MaxResponseBodySize)
+	MaxResponseBodySize = 64
+
+	cases := []struct {
+		name      string
+		ctx       context.Context
+		invoker   *invoke.Invoker
+		mcode     string
+		retryable bool
+		timeout   bool
+	}{
+		{"dns not found", nil, invoke.Addr("missing.example:80").Get("/").Client(dnsClient(&net.DNSError{Err: "no such host", Name: "missing.example", IsNotFound: true})), MCODE_INVOKE_DNS_NOT_FOUND, false, false},
+		{"dns temporary", nil, invoke.Addr("flaky.example:80").Get("/").Client(dnsClient(&net.DNSError{Err: "server misbehaving", Name: "flaky.example", IsTemporary: true})), MCODE_INVOKE_DNS_FAILED, true, false},
+		{"refused", nil, invoke.Addr(closedAddr).Get("/"), MCODE_INVOKE_CONN_REFUSED, true, false},
+		{"reset", nil, invoke.Addr(addr).Post("/reset").Payload([]byte("x")), MCODE_INVOKE_CONN_RESET, true, false},
+		{"tls handshake", nil, invoke.Addr(addr).Get("/").TLS(), MCODE_INVOKE_TLS_HANDSHAKE, false, false},
+		{"tls version", nil, invoke.Addr(strings.TrimPrefix(tls12Server.URL, "https://")).Get("/").TLS().Client(tls13Client), MCODE_INVOKE_TLS_HANDSHAKE, false, false},
+		{"tls verify", nil, invoke.Addr(tlsAddr).Get("/").TLS(), MCODE_INVOKE_TLS_VERIFY, false, false},
+		{"canceled", canceled, invoke.Addr(addr).Get("/slow"), MCODE_INVOKE_CANCELED, false, false},
+		{"deadline", nil, invoke.Addr(addr).Get("/slow").Timeout(50 * time.Millisecond), MCODE_INVOKE_TIMEOUT, true, true},
+		{"too large", nil, invoke.Addr(addr).Get("/large"), MCODE_INVOKE_TOO_LARGE, false, false},
+		{"malformed", nil, invoke.Addr(addr).Get("/malformed"), MCODE_INVOKE_MALFORMED, false, false},
+	}
+
+	for _, c := range cases {
+		ctx := c.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		_, err := Call[string](ctx, c.invoker)
+		if err == nil || err.Mcode() != c.mcode {
+			t.Errorf("%s: expect %s, got %v", c.name, c.mcode, err)
+			continue
+		}
+
+		// 解析响应的错误不是传输错误
+		var transportErr *TransportError
+		if !errors.As(err, &transportErr) {
+			continue
+		}
+		if transportErr.Retryable() != c.retryable || transportErr.Timeout() != c.timeout {
+			t.Errorf("%s: unexpected flags retryable=%v timeout=%v", c.name, transportErr.Retryable(), transportErr.Timeout())
+		}
+	}
+}
+
+func TestClassifyTypes(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		mcode string
+	}{
+		{&invoke.InvokeError{Action: "Request", Err: http.ErrBodyReadAfterClose}, MCODE_INVOKE_FAILED},
+		{&invoke.InvokeError{Action: "Request", Err: textproto.ProtocolError("malformed MIME header line")}, MCODE_INVOKE_MALFORMED},
+		{&invoke.InvokeError{Action: "Request", Err: ErrBodyTooLarge}, MCODE_INVOKE_TOO_LARGE},
+		// 无关的错误消息不会被误判
+		{errors.New("config value too large, malformed tls: section"), MCODE_INVOKE_FAILED},
+	} {
+		if got := Classify(c.err); got.Mcode() != c.mcode {
+			t.Errorf("%v: expect %s got %s", c.err, c.mcode, got.Mcode())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package invokeutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/hello-pionex/mystic-go/code"
+)
+
+// UpstreamBodySnippetSize UpstreamError 中保留的响应体的最大长度
+var UpstreamBodySnippetSize = 512
+
+// UpstreamError 调用上游失败的详细信息，实现 code.Error
+// 默认的mcode: 业务错误使用上游的mcode，HTTP状态错误为 MCODE_INVOKE_FAILED，响应体为空或无法解析为 MCODE_INVOKE_MALFORMED，
+// 传输错误按 Classify 分类，调用方可以通过 Propagate 或 Map 决定对外返回的mcode
+//
+//	var upstreamErr *invokeutils.UpstreamError
+//	if errors.As(err, &upstreamErr) && upstreamErr.Status == http.StatusConflict {
+//		return upstreamErr.Map(map[string]string{"ORDER_LOCKED": "TRY_LATER"})
+//	}
+type UpstreamError struct {
+	Service         string // 上游服务名，未知时为空
+	Status          int    // HTTP状态码，没有收到响应时为0
+	UpstreamMcode   string // 上游返回的mcode
+	UpstreamMessage string // 上游返回的消息
+	Body            string // 截断后的响应体
+
+	mcode   string
+	message string
+	cause   error
+}
+
+func newUpstreamError(service string, status int, mcode, message string, cause error) *UpstreamError {
+	return &UpstreamError{
+		Service: service,
+		Status:  status,
+		mcode:   mcode,
+		message: message,
+		cause:   cause,
+	}
+}
+
+func (err *UpstreamError) Error() string {
+	return fmt.Sprintf("%s,%s", err.mcode, err.message)
+}
+
+func (err *UpstreamError) Mcode() string {
+	return err.mcode
+}
+
+func (err *UpstreamError) Message() string {
+	return err.message
+}
+
+// Unwrap 传输错误时为 *TransportError，解析失败时为解析的错误
+func (err *UpstreamError) Unwrap() error {
+	return err.cause
+}
+
+// Propagate 使用上游返回的mcode与消息，上游没有返回mcode时返回自身
+func (err *UpstreamError) Propagate() code.Error {
+	if err.UpstreamMcode == "" {
+		return err
+	}
+	propagated := *err
+	propagated.mcode = err.UpstreamMcode
+	propagated.message = err.UpstreamMessage
+	return &propagated
+}
+
+// Map 按映射转换上游返回的mcode，消息使用上游的消息，没有对应的映射时返回自身
+func (err *UpstreamError) Map(mapping map[string]string) code.Error {
+	mcode, ok := mapping[err.UpstreamMcode]
+	if !ok || err.UpstreamMcode == "" {
+		return err
+	}
+	mapped := *err
+	mapped.mcode = mcode
+	mapped.message = err.UpstreamMessage
+	return &mapped
+}
+
+// withResponse 为错误补充服务名与响应体，非 UpstreamError 的错误被包装
+func withResponse(err code.Error, service string, status int, body []byte) code.Error {
+	upstreamErr, ok := err.(*UpstreamError)
+	if !ok {
+		upstreamErr = newUpstreamError(service, status, err.Mcode(), err.Message(), err)
+	}
+	if upstreamErr.Service == "" {
+		upstreamErr.Service = service
+	}
+	if upstreamErr.Status == 0 {
+		upstreamErr.Status = status
+	}
+	if upstreamErr.Body == "" {
+		upstreamErr.Body = bodySnippet(body)
+	}
+	return upstreamErr
+}
+
+// statusError 非200的响应，按标准封装解析上游的错误信息
+func statusError(service string, status int, body []byte) code.Error {
+	var res *Response
+	if len(body) > 0 {
+		res = &Response{}
+		if json.Unmarshal(body, res) != nil {
+			res = nil
+		}
+	}
+	return withResponse(ExtractHeader(service, nil, status, res, nil), service, status, body)
+}
+
+// bodySnippet 在utf8边界截断响应体
+func bodySnippet(body []byte) string {
+	if len(body) <= UpstreamBodySnippetSize {
+		return string(body)
+	}
+
+	n := UpstreamBodySnippetSize
+	for n > 0 && !utf8.RuneStart(body[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", body[:n], len(body)-n)
+}
+
+// statusMessage 与之前保持一致的状态错误消息
+func statusMessage(status int) string {
+	return fmt.Sprintf("http status: %d", status)
+}
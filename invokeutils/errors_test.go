@@ -0,0 +1,150 @@
+package invokeutils
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+func TestUpstreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/locked":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"result":false,"mcode":"ORDER_LOCKED","message":"order is locked"}`))
+		case "/gateway":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>" + strings.Repeat("x", 1024) + "</html>"))
+		default:
+			w.Write([]byte(`{"result":false,"mcode":"NO_BALANCE","message":"no money"}`))
+		}
+	}))
+	defer server.Close()
+
+	invoke.SetResolver(invoke.StaticResolver{"orders": {strings.TrimPrefix(server.URL, "http://")}})
+	defer invoke.SetResolver(nil)
+	ctx := context.Background()
+
+	_, err := Call[string](ctx, invoke.Addr("service://orders").Post("/locked"))
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		t.Fatalf("expect UpstreamError, got %T %v", err, err)
+	}
+	if err.Mcode() != MCODE_INVOKE_FAILED || err.Message() != "http status: 409" {
+		t.Fatalf("default mcode changed: %v", err)
+	}
+	if upstreamErr.Service != "orders" || upstreamErr.Status != http.StatusConflict ||
+		upstreamErr.UpstreamMcode != "ORDER_LOCKED" || upstreamErr.UpstreamMessage != "order is locked" ||
+		!strings.Contains(upstreamErr.Body, "ORDER_LOCKED") {
+		t.Fatalf("unexpected upstream error %+v", upstreamErr)
+	}
+	if propagated := upstreamErr.Propagate(); propagated.Mcode() != "ORDER_LOCKED" || propagated.Message() != "order is locked" {
+		t.Fatalf("unexpected propagated error %v", propagated)
+	}
+	if mapped := upstreamErr.Map(map[string]string{"ORDER_LOCKED": "TRY_LATER"}); mapped.Mcode() != "TRY_LATER" {
+		t.Fatalf("unexpected mapped error %v", mapped)
+	}
+	if same := upstreamErr.Map(map[string]string{"OTHER": "X"}); same != upstreamErr {
+		t.Fatalf("unmapped mcode should keep the error, got %v", same)
+	}
+
+	_, err = Call[string](ctx, invoke.Addr("service://orders").Get("/gateway").OnlyStatus200().AutoCloseResponseBody())
+	if !errors.As(err, &upstreamErr) || upstreamErr.Status != http.StatusBadGateway || upstreamErr.UpstreamMcode != "" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	_, err = Call[string](ctx, invoke.Addr("service://orders").Get("/gateway"))
+	if !errors.As(err, &upstreamErr) || !strings.HasSuffix(upstreamErr.Body, "...(truncated 525 bytes)") {
+		t.Fatalf("body not truncated: %+v", upstreamErr)
+	}
+
+	_, err = Call[string](ctx, invoke.Addr("service://orders").Get("/"))
+	if !errors.As(err, &upstreamErr) || err.Mcode() != "NO_BALANCE" || upstreamErr.Status != http.StatusOK || upstreamErr.Service != "orders" {
+		t.Fatalf("unexpected business error %v", err)
+	}
+
+	_, err = Call[string](ctx, invoke.Addr("service://missing").Get("/"))
+	var transportErr *TransportError
+	if !errors.As(err, &upstreamErr) || upstreamErr.Service != "missing" || upstreamErr.Status != 0 || !errors.As(err, &transportErr) {
+		t.Fatalf("unexpected transport error %T %v", err, err)
+	}
+}
+
+func TestExtractHttpResponseClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/locked" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"result":false,"mcode":"ORDER_LOCKED"}`))
+			return
+		}
+		w.Write([]byte(`{"result":true,"data":"ok"}`))
+	}))
+	defer server.Close()
+
+	counter := &closeCounter{}
+	client := &http.Client{Transport: counter}
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	for _, invoker := range []*invoke.Invoker{
+		invoke.Addr(addr).Get("/locked").OnlyStatus200().Client(client),
+		invoke.Addr(addr).Get("/locked").Client(client),
+		invoke.Addr(addr).Get("/").Client(client),
+	} {
+		rsp, err := invoker.Request()
+		var out string
+		codeErr := ExtractHttpResponse("orders", err, rsp, &out)
+		if rsp.StatusCode == http.StatusOK && (codeErr != nil || out != "ok") {
+			t.Fatalf("unexpected result %q %v", out, codeErr)
+		}
+		if rsp.StatusCode != http.StatusOK && (codeErr == nil || codeErr.Mcode() != MCODE_INVOKE_FAILED) {
+			t.Fatalf("unexpected error %v", codeErr)
+		}
+	}
+
+	// 调用出错但仍有响应时同样关闭响应体
+	atomic.AddInt64(&counter.opened, 1)
+	rsp := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Body:       &countedBody{ReadCloser: ioutil.NopCloser(strings.NewReader("bad gateway")), counter: counter},
+	}
+	if codeErr := ExtractHttpResponse("orders", errors.New("http status: 502"), rsp, nil); codeErr == nil {
+		t.Fatal("expect status error")
+	}
+
+	// 状态码为200时不能丢弃调用错误
+	atomic.AddInt64(&counter.opened, 1)
+	rsp = &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       &countedBody{ReadCloser: ioutil.NopCloser(strings.NewReader(`{"result":true,"data":"ok"}`)), counter: counter},
+	}
+	var out string
+	if codeErr := ExtractHttpResponse("orders", errors.New("after request failed"), rsp, &out); codeErr == nil || out != "" {
+		t.Fatalf("invoke error should be returned, got %q %v", out, codeErr)
+	}
+
+	if opened, closed := atomic.LoadInt64(&counter.opened), atomic.LoadInt64(&counter.closed); opened != 5 || closed != opened {
+		t.Fatalf("response bodies not closed, opened=%d closed=%d", opened, closed)
+	}
+}
+
+func TestBodySnippetUTF8(t *testing.T) {
+	defer func(size int) { UpstreamBodySnippetSize = size }(UpstreamBodySnippetSize)
+	UpstreamBodySnippetSize = 4
+
+	// "余额不足" 每个字3字节，第4个字节不是字符的开头
+	snippet := bodySnippet([]byte("余额不足"))
+	if snippet != "余...(truncated 9 bytes)" || !utf8.ValidString(snippet) {
+		t.Fatalf("unexpected snippet %q", snippet)
+	}
+	if snippet := bodySnippet([]byte("abcd")); snippet != "abcd" {
+		t.Fatalf("unexpected snippet %q", snippet)
+	}
+}
@@ -0,0 +1,205 @@
+package invokeutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hello-pionex/mystic-go/code"
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+type fanoutMode int
+
+const (
+	fanoutCollectAll fanoutMode = iota
+	fanoutFailFast
+	fanoutQuorum
+)
+
+// FanoutPolicy 并发调用的策略，默认最多8个并发，等待全部调用结束
+type FanoutPolicy struct {
+	concurrency int
+	timeout     time.Duration
+	mode        fanoutMode
+	quorum      int
+	envelope    Envelope
+}
+
+// NewFanoutPolicy 创建并发调用策略
+func NewFanoutPolicy() *FanoutPolicy {
+	return &FanoutPolicy{
+		concurrency: 8,
+		envelope:    StandardEnvelope,
+	}
+}
+
+// Concurrency 同时进行的调用数上限
+func (policy *FanoutPolicy) Concurrency(n int) *FanoutPolicy {
+	if n > 0 {
+		policy.concurrency = n
+	}
+	return policy
+}
+
+// Timeout 所有调用共享的截止时间，超时后未开始的调用不再执行
+func (policy *FanoutPolicy) Timeout(d time.Duration) *FanoutPolicy {
+	policy.timeout = d
+	return policy
+}
+
+// FailFast 任意调用失败时取消其余调用并返回该错误
+func (policy *FanoutPolicy) FailFast() *FanoutPolicy {
+	policy.mode = fanoutFailFast
+	return policy
+}
+
+// CollectAll 等待全部调用结束，每个调用的错误记录在各自的结果中
+func (policy *FanoutPolicy) CollectAll() *FanoutPolicy {
+	policy.mode = fanoutCollectAll
+	return policy
+}
+
+// Quorum 有k个调用成功后取消其余调用，确定无法达到k个成功时提前返回错误，k最小为1
+func (policy *FanoutPolicy) Quorum(k int) *FanoutPolicy {
+	if k < 1 {
+		k = 1
+	}
+	policy.mode = fanoutQuorum
+	policy.quorum = k
+	return policy
+}
+
+// Envelope 解析响应的方式，默认 StandardEnvelope
+func (policy *FanoutPolicy) Envelope(envelope Envelope) *FanoutPolicy {
+	policy.envelope = envelope
+	return policy
+}
+
+// Result 单个调用的结果
+type Result[T any] struct {
+	Value T
+	Err   code.Error
+}
+
+// Fanout 按策略并发执行调用，结果与invokers的顺序一致
+// 返回的错误表示按策略整体失败: FailFast为第一个失败的错误，CollectAll为存在失败，Quorum为成功数不足
+//
+//	results, err := invokeutils.Fanout[Page](ctx, invokeutils.NewFanoutPolicy().Concurrency(4).FailFast(), invokers...)
+func Fanout[T any](ctx context.Context, policy *FanoutPolicy, invokers ...*invoke.Invoker) ([]Result[T], code.Error) {
+	total := len(invokers)
+	results := make([]Result[T], total)
+	if policy.mode == fanoutQuorum && policy.quorum > total {
+		return results, code.NewMcodef(MCODE_INVOKE_FAILED, "quorum %d exceeds %d calls", policy.quorum, total)
+	}
+
+	if policy.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.timeout)
+		defer cancel()
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mutex     sync.Mutex
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, policy.concurrency)
+		succeeded int
+		failed    int
+		stopped   bool
+		stopErr   code.Error
+	)
+
+	// stop 按策略提前结束，未结束的调用被取消
+	stop := func(err code.Error) {
+		stopped = true
+		stopErr = err
+		cancel()
+	}
+
+	record := func(i int, value T, err code.Error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if err != nil && stopped {
+			err = code.NewMcode(MCODE_INVOKE_CANCELED, "canceled by fanout")
+		}
+		results[i] = Result[T]{Value: value, Err: err}
+		if stopped {
+			return
+		}
+
+		if err == nil {
+			succeeded++
+		} else {
+			failed++
+		}
+
+		switch policy.mode {
+		case fanoutFailFast:
+			if err != nil {
+				stop(err)
+			}
+		case fanoutQuorum:
+			if succeeded >= policy.quorum {
+				stop(nil)
+			} else if failed > total-policy.quorum {
+				stop(code.NewMcodef(MCODE_INVOKE_FAILED, "quorum not reached, %d of %d calls failed", failed, total))
+			}
+		}
+	}
+
+	for i, invoker := range invokers {
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+
+		if ctx.Err() != nil {
+			mutex.Lock()
+			for j := i; j < total; j++ {
+				if !stopped && parent.Err() == context.DeadlineExceeded {
+					results[j].Err = code.NewMcode(MCODE_INVOKE_TIMEOUT, "fanout deadline exceeded")
+				} else {
+					results[j].Err = code.NewMcode(MCODE_INVOKE_CANCELED, "canceled by fanout")
+				}
+			}
+			mutex.Unlock()
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, invoker *invoke.Invoker) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			value, err := CallWith[T](ctx, invoker, policy.envelope)
+			record(i, value, err)
+		}(i, invoker)
+	}
+	wg.Wait()
+
+	switch {
+	case stopErr != nil:
+		return results, stopErr
+	case policy.mode == fanoutQuorum && !stopped:
+		// 截止时间已到或者外部取消
+		return results, code.NewMcodef(MCODE_INVOKE_FAILED, "quorum not reached, %d of %d calls succeeded", succeeded, total)
+	}
+
+	if !stopped {
+		for _, result := range results {
+			if result.Err != nil {
+				if policy.mode == fanoutFailFast {
+					return results, result.Err
+				}
+				return results, code.NewMcodef(MCODE_INVOKE_FAILED, "%d of %d calls failed", total-succeeded, total)
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,182 @@
+package invokeutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hello-pionex/mystic-go/invoke"
+)
+
+// fanoutServer /v/<n>?delay=<ms> 返回n，/fail 返回业务错误，/slow 直到请求取消才返回
+type fanoutServer struct {
+	*httptest.Server
+	inflight    int64
+	maxInflight int64
+}
+
+func newFanoutServer() *fanoutServer {
+	server := &fanoutServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&server.inflight, 1)
+		defer atomic.AddInt64(&server.inflight, -1)
+		for {
+			max := atomic.LoadInt64(&server.maxInflight)
+			if n <= max || atomic.CompareAndSwapInt64(&server.maxInflight, max, n) {
+				break
+			}
+		}
+
+		switch {
+		case r.URL.Path == "/fail":
+			w.Write([]byte(`{"result":false,"mcode":"NO_BALANCE"}`))
+		case r.URL.Path == "/slow":
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		case strings.HasPrefix(r.URL.Path, "/v/"):
+			delay, _ := strconv.Atoi(r.URL.Query().Get("delay"))
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+			w.Write([]byte(`{"result":true,"data":` + strings.TrimPrefix(r.URL.Path, "/v/") + `}`))
+		}
+	}))
+	return server
+}
+
+func (server *fanoutServer) invokers(paths ...string) []*invoke.Invoker {
+	invokers := make([]*invoke.Invoker, len(paths))
+	for i, path := range paths {
+		invokers[i] = invoke.Addr(strings.TrimPrefix(server.URL, "http://")).Get(path)
+	}
+	return invokers
+}
+
+func expectMcodes(t *testing.T, results []Result[int], mcodes ...string) {
+	t.Helper()
+	for i, mcode := range mcodes {
+		got := ""
+		if results[i].Err != nil {
+			got = results[i].Err.Mcode()
+		}
+		if got != mcode {
+			t.Fatalf("result %d: expect %q got %q", i, mcode, got)
+		}
+	}
+}
+
+func TestFanoutFailFast(t *testing.T) {
+	server := newFanoutServer()
+	defer server.Close()
+
+	start := time.Now()
+	results, err := Fanout[int](context.Background(), NewFanoutPolicy().FailFast(), server.invokers("/slow", "/fail", "/slow")...)
+	if err == nil || err.Mcode() != "NO_BALANCE" || time.Since(start) > 2*time.Second {
+		t.Fatalf("expect first failure, got %v after %v", err, time.Since(start))
+	}
+	expectMcodes(t, results, MCODE_INVOKE_CANCELED, "NO_BALANCE", MCODE_INVOKE_CANCELED)
+
+	// 没有开始的调用也被取消
+	results, err = Fanout[int](context.Background(), NewFanoutPolicy().FailFast().Concurrency(1), server.invokers("/fail", "/v/1", "/v/2")...)
+	if err == nil || err.Mcode() != "NO_BALANCE" {
+		t.Fatalf("expect first failure, got %v", err)
+	}
+	expectMcodes(t, results, "NO_BALANCE", MCODE_INVOKE_CANCELED, MCODE_INVOKE_CANCELED)
+}
+
+func TestFanoutCollectAll(t *testing.T) {
+	server := newFanoutServer()
+	defer server.Close()
+
+	results, err := Fanout[int](context.Background(), NewFanoutPolicy().CollectAll(), server.invokers("/v/1", "/fail", "/v/3")...)
+	if err == nil || err.Mcode() != MCODE_INVOKE_FAILED {
+		t.Fatalf("expect failure, got %v", err)
+	}
+	expectMcodes(t, results, "", "NO_BALANCE", "")
+	if results[0].Value != 1 || results[2].Value != 3 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	if _, err := Fanout[int](context.Background(), NewFanoutPolicy(), server.invokers("/v/1", "/v/2")...); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestFanoutQuorum(t *testing.T) {
+	server := newFanoutServer()
+	defer server.Close()
+
+	start := time.Now()
+	results, err := Fanout[int](context.Background(), NewFanoutPolicy().Quorum(2), server.invokers("/v/1", "/slow", "/v/3")...)
+	if err != nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("expect quorum reached, got %v after %v", err, time.Since(start))
+	}
+	expectMcodes(t, results, "", MCODE_INVOKE_CANCELED, "")
+
+	// 两个失败后不可能再有两个成功，提前返回
+	start = time.Now()
+	results, err = Fanout[int](context.Background(), NewFanoutPolicy().Quorum(2), server.invokers("/fail", "/slow", "/fail")...)
+	if err == nil || err.Mcode() != MCODE_INVOKE_FAILED || time.Since(start) > 2*time.Second {
+		t.Fatalf("expect early quorum failure, got %v after %v", err, time.Since(start))
+	}
+	expectMcodes(t, results, "NO_BALANCE", MCODE_INVOKE_CANCELED, "NO_BALANCE")
+
+	if _, err := Fanout[int](context.Background(), NewFanoutPolicy().Quorum(3), server.invokers("/v/1", "/v/2")...); err == nil {
+		t.Fatal("expect error when quorum exceeds calls")
+	}
+}
+
+func TestFanoutConcurrency(t *testing.T) {
+	server := newFanoutServer()
+	defer server.Close()
+
+	paths := make([]string, 12)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/v/%d?delay=20", i)
+	}
+	if _, err := Fanout[int](context.Background(), NewFanoutPolicy().Concurrency(3), server.invokers(paths...)...); err != nil {
+		t.Fatal(err)
+	}
+	if max := atomic.LoadInt64(&server.maxInflight); max > 3 || max < 2 {
+		t.Fatalf("expect at most 3 concurrent calls, got %d", max)
+	}
+}
+
+func TestFanoutDeadline(t *testing.T) {
+	server := newFanoutServer()
+	defer server.Close()
+
+	start := time.Now()
+	policy := NewFanoutPolicy().Concurrency(1).Timeout(50 * time.Millisecond)
+	results, err := Fanout[int](context.Background(), policy, server.invokers("/slow", "/v/1", "/v/2")...)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("expect deadline failure, got %v after %v", err, time.Since(start))
+	}
+	expectMcodes(t, results, MCODE_INVOKE_TIMEOUT, MCODE_INVOKE_TIMEOUT, MCODE_INVOKE_TIMEOUT)
+}
+
+func TestFanoutOrder(t *testing.T) {
+	server := newFanoutServer()
+	defer server.Close()
+
+	paths := make([]string, 8)
+	for i := range paths {
+		// 越靠前的调用越晚返回
+		paths[i] = fmt.Sprintf("/v/%d?delay=%d", i, (len(paths)-i)*5)
+	}
+	results, err := Fanout[int](context.Background(), NewFanoutPolicy(), server.invokers(paths...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Value != i {
+			t.Fatalf("result %d has value %d", i, result.Value)
+		}
+	}
+}
@@ -2,12 +2,7 @@ package invokeutils
 
 import (
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net"
 	"net/http"
-	"net/url"
-	"os"
 
 	"github.com/hello-pionex/mystic-go/code"
 )
@@ -22,56 +17,47 @@ type Response struct {
 var (
 	MCODE_INVOKE_TIMEOUT = "INVOKE_TIMEOUT"
 	MCODE_INVOKE_FAILED  = "INVOKE_FAILED"
+	// MCODE_INVOKE_CANCELED 调用被取消，或者因为其他调用的结果而被取消或者没有执行
+	MCODE_INVOKE_CANCELED = "INVOKE_CANCELED"
+	// MCODE_INVOKE_DNS_NOT_FOUND 域名不存在
+	MCODE_INVOKE_DNS_NOT_FOUND = "INVOKE_DNS_NOT_FOUND"
+	// MCODE_INVOKE_DNS_FAILED 域名解析暂时失败
+	MCODE_INVOKE_DNS_FAILED = "INVOKE_DNS_FAILED"
+	// MCODE_INVOKE_CONN_REFUSED 连接被拒绝，请求没有发出
+	MCODE_INVOKE_CONN_REFUSED = "INVOKE_CONN_REFUSED"
+	// MCODE_INVOKE_CONN_RESET 连接被重置或提前关闭，请求可能已被处理
+	MCODE_INVOKE_CONN_RESET = "INVOKE_CONN_RESET"
+	// MCODE_INVOKE_TLS_HANDSHAKE TLS握手失败，例如协议版本不匹配或者对端不是TLS
+	MCODE_INVOKE_TLS_HANDSHAKE = "INVOKE_TLS_HANDSHAKE"
+	// MCODE_INVOKE_TLS_VERIFY 证书校验或公钥固定失败
+	MCODE_INVOKE_TLS_VERIFY = "INVOKE_TLS_VERIFY"
+	// MCODE_INVOKE_TOO_LARGE 响应体或响应头超出限制
+	MCODE_INVOKE_TOO_LARGE = "INVOKE_TOO_LARGE"
+	// MCODE_INVOKE_MALFORMED 响应无法解析
+	MCODE_INVOKE_MALFORMED = "INVOKE_MALFORMED"
 )
 
 // ExtractHeader 解析包中的错误码(该封装已经达成共识)
 // 即：{result:true,mcode:"<code>",data:{}}
+// 返回的错误为 *UpstreamError，非200时res为响应体按封装解析的结果，可以为nil
 func ExtractHeader(name string, invokeErr error, statusCode int, res *Response, out interface{}) code.Error {
 	if statusCode == 0 {
 		// HTTP 调用过程出错
-		urlErr, ok := invokeErr.(*url.Error)
-		if ok {
-			// 超时错误
-			if urlErr.Timeout() {
-				return code.NewMcode(MCODE_INVOKE_TIMEOUT, "http timeout")
-			}
-
-			if netErr, ok := urlErr.Err.(net.Error); ok {
-				if netErr.Timeout() {
-					return code.NewMcode(MCODE_INVOKE_TIMEOUT, "network timeout")
-				}
-
-				if netOpErr, ok := netErr.(*net.OpError); ok {
-					if netOpErr.Timeout() {
-						return code.NewMcode(MCODE_INVOKE_TIMEOUT, "network operation timeout")
-					}
-
-					if sysCallErr, ok := netOpErr.Err.(*os.SyscallError); ok {
-						if sysCallErr.Syscall == "connectx" {
-							return code.NewMcodef(MCODE_INVOKE_FAILED,
-								"connect failed,addr=%v", netOpErr.Addr.String())
-						}
-						return code.NewMcode(MCODE_INVOKE_FAILED, "invoke failed for net problem")
-					}
-				}
-			}
-
-			return code.NewMcodef(MCODE_INVOKE_FAILED, "invoke error,%v", urlErr)
+		if invokeErr == nil {
+			return newUpstreamError(name, 0, MCODE_INVOKE_FAILED, "invoke failed", nil)
 		}
-
-		// 其他错误
-		return code.NewMcode(
-			MCODE_INVOKE_FAILED,
-			invokeErr.Error(),
-		)
+		classified := Classify(invokeErr)
+		return newUpstreamError(name, 0, classified.Mcode(), classified.Message(), classified)
 	}
 
 	// 返回状态出错
 	if statusCode != http.StatusOK {
-		return code.NewMcode(
-			MCODE_INVOKE_FAILED,
-			fmt.Sprintf("http status: %d", statusCode),
-		)
+		err := newUpstreamError(name, statusCode, MCODE_INVOKE_FAILED, statusMessage(statusCode), nil)
+		if res != nil {
+			err.UpstreamMcode = res.Code
+			err.UpstreamMessage = res.Message
+		}
+		return err
 	}
 
 	// 处理结果出错
@@ -80,7 +66,10 @@ func ExtractHeader(name string, invokeErr error, statusCode int, res *Response,
 		if mcode == "" {
 			mcode = MCODE_INVOKE_FAILED
 		}
-		return code.NewMcode(mcode, res.Message)
+		err := newUpstreamError(name, statusCode, mcode, res.Message, nil)
+		err.UpstreamMcode = res.Code
+		err.UpstreamMessage = res.Message
+		return err
 	}
 
 	// 无需解析结果
@@ -90,50 +79,24 @@ func ExtractHeader(name string, invokeErr error, statusCode int, res *Response,
 
 	err := json.Unmarshal(res.Data, out)
 	if err != nil {
-		return code.NewMcode(
-			MCODE_INVOKE_FAILED,
-			"parse return json payload failed",
-		)
+		return newUpstreamError(name, statusCode, MCODE_INVOKE_MALFORMED, "parse return json payload failed", err)
 	}
 
 	return nil
 }
 
-// ExtractHttpResponse 解析标准http.Response为输出
+// ExtractHttpResponse 解析标准http.Response为输出，非200时保留响应体中上游的错误信息，响应体总会被关闭
 func ExtractHttpResponse(name string, invokeErr error, rsp *http.Response, out interface{}) code.Error {
-	var commonResp Response
-	var errCode code.Error
-
-	if invokeErr == nil && rsp != nil {
+	if rsp != nil && rsp.Body != nil {
 		defer rsp.Body.Close()
 	}
 
-	statusCode := 0
-	if rsp != nil {
-		statusCode = rsp.StatusCode
-	}
-
-	if statusCode == http.StatusOK {
-		body, err := ioutil.ReadAll(rsp.Body)
-		if err != nil {
-			errCode = code.NewMcode(MCODE_INVOKE_FAILED, "read response body failed")
-			return errCode
-		}
-
-		if len(body) == 0 {
-			errCode = code.NewMcode(MCODE_INVOKE_FAILED, "return json body empty")
-			return errCode
-		}
-
-		err = json.Unmarshal(body, &commonResp)
-		if err != nil {
-			errCode = code.NewMcode(MCODE_INVOKE_FAILED, "return json body error")
-			return errCode
-		}
+	// 与 CallWith 一致，状态码不是200时仍按响应处理
+	if rsp == nil || (invokeErr != nil && rsp.StatusCode == http.StatusOK) {
+		return ExtractHeader(name, invokeErr, 0, nil, nil)
 	}
 
-	errCode = ExtractHeader(name, invokeErr, statusCode, &commonResp, out)
-	return errCode
+	return extractResponse(name, rsp, StandardEnvelope, out)
 }
 
 func NewParser(out interface{}) func(interface{}, *http.Response, error) error {